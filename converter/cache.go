@@ -2,6 +2,8 @@ package converter
 
 import (
 	"fmt"
+	"math"
+
 	"github.com/go-graphite/go-whisper"
 )
 
@@ -10,17 +12,33 @@ type timeSeriesCache struct {
 	sources   []*convertSource
 	positions []int
 	size      int
+	// targetRetentions holds the max retention of the whisper archive to update for each source,
+	// -1 for automatic selection. The sources may have different retentions from the storage schemas.
+	targetRetentions []int
+	// lastFlushed is the time of the last row written to the whisper files
+	lastFlushed int
 }
 
 func newTimeSeriesCache(sources []*convertSource, cacheSize int) *timeSeriesCache {
 	tsc := new(timeSeriesCache)
 	tsc.sources = sources
 	tsc.size = cacheSize
+	tsc.targetRetentions = make([]int, len(sources))
+	for i := range tsc.targetRetentions {
+		tsc.targetRetentions[i] = -1
+	}
 	tsc.positions = make([]int, len(sources))
 	tsc.reset()
 	return tsc
 }
 
+// setStep selects the archive with the precision step of every source as target
+func (tsc *timeSeriesCache) setStep(step int) {
+	for i, source := range tsc.sources {
+		tsc.targetRetentions[i] = archiveMaxRetention(source.Whisper, step)
+	}
+}
+
 func (tsc *timeSeriesCache) reset() {
 	tsc.values = make([]*whisper.TimeSeriesPoint, tsc.size*len(tsc.sources))
 	for i := 0; i < len(tsc.sources); i++ {
@@ -52,21 +70,18 @@ func (tsc *timeSeriesCache) rowForSource(source int) []*whisper.TimeSeriesPoint
 func (tsc *timeSeriesCache) flush() error {
 	if tsc.positions[0] != 0 {
 		for i, source := range tsc.sources {
-			if err := source.Whisper.UpdateMany(tsc.rowForSource(i)); err != nil {
+			points := tsc.rowForSource(i)
+			if err := source.Whisper.UpdateManyForArchive(points, tsc.targetRetentions[i]); err != nil {
 				return fmt.Errorf("could not update whisper file: %s", err)
 			}
+			for _, pt := range points {
+				if !math.IsNaN(pt.Value) {
+					source.Points++
+				}
+			}
 		}
+		tsc.lastFlushed = tsc.values[tsc.positions[0]-1].Time
 		tsc.reset()
 	}
 	return nil
 }
-
-func (tsc *timeSeriesCache) close() error {
-	tsc.flush()
-	for _, source := range tsc.sources {
-		if err := source.Whisper.Close(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+func TestCacheSchemaRetentions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeRulesFile(t, `[rta]
+pattern = \.rta$
+retentions = 60s:1d
+
+[default]
+pattern = .*
+retentions = 60s:7d,1h:30d
+`)
+	defer os.Remove(path)
+	rules, err := LoadSchemaRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retention, _ := whisper.ParseRetentionDefs("60s:365d")
+	sources := make([]*convertSource, 0)
+	for _, label := range []string{"rta", "pl"} {
+		metric := "host1.service1." + label
+		cs, err := newConvertSource(label, dir, &metricPaths{Metric: metric, Destination: filepath.Join(dir, "dest", label+".wsp")}, rules.Retentions(metric, retention), whisper.Average, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cs.Whisper.Close()
+		sources = append(sources, cs)
+	}
+	if sources[0].Whisper.Retentions()[0].MaxRetention() == sources[1].Whisper.Retentions()[0].MaxRetention() {
+		t.Fatal("the labels must match schemas with different retentions")
+	}
+
+	now := int(time.Now().Unix())
+	now -= now % 60
+	cache := newTimeSeriesCache(sources, 100)
+	cache.setStep(60)
+	for i := 10; i > 0; i-- {
+		if err := cache.addRow(now-i*60, []float64{float64(i), float64(i * 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, cs := range sources {
+		if cs.Points != 10 {
+			t.Errorf("%s: expected 10 points, got %d", cs.Label, cs.Points)
+		}
+		series, err := cs.Whisper.Fetch(now-11*60, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if series == nil {
+			t.Fatalf("%s: no data", cs.Label)
+		}
+		found := 0
+		for _, pt := range series.Points() {
+			factor := 1
+			if i == 1 {
+				factor = 10
+			}
+			if pt.Value == float64((now-pt.Time)/60*factor) {
+				found++
+			}
+		}
+		if found != 10 {
+			t.Errorf("%s: expected 10 values in the whisper file, found %d: %v", cs.Label, found, series.Values())
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/logging"
+)
+
+const (
+	carbonTimeout           = 30 * time.Second
+	carbonMaxReconnectDelay = 30 * time.Second
+	// carbonMaxDatagramSize keeps udp packets below the usual mtu
+	carbonMaxDatagramSize = 1400
+	// carbonQueueSize is the number of batches buffered before Send blocks
+	carbonQueueSize = 100
+)
+
+// carbonConnection sends chunks of data to carbon and reconnects if the connection is lost
+type carbonConnection struct {
+	ctx     context.Context
+	network string
+	address string
+	conn    net.Conn
+}
+
+func newCarbonConnection(ctx context.Context, network, address string) (*carbonConnection, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("unsupported carbon protocol \"%s\"", network)
+	}
+	cc := &carbonConnection{
+		ctx:     ctx,
+		network: network,
+		address: address,
+	}
+	if err := cc.connect(); err != nil {
+		return nil, fmt.Errorf("could not connect to carbon: %s", err)
+	}
+	return cc, nil
+}
+
+func (cc *carbonConnection) connect() error {
+	var err error
+	cc.conn, err = net.DialTimeout(cc.network, cc.address, carbonTimeout)
+	return err
+}
+
+func (cc *carbonConnection) writeChunk(chunk []byte) error {
+	if err := cc.conn.SetWriteDeadline(time.Now().Add(carbonTimeout)); err != nil {
+		return err
+	}
+	if cc.network == "tcp" {
+		_, err := cc.conn.Write(chunk)
+		return err
+	}
+
+	// udp: split the chunk at line endings into datagrams
+	for len(chunk) > 0 {
+		end := len(chunk)
+		if end > carbonMaxDatagramSize {
+			end = bytes.LastIndexByte(chunk[:carbonMaxDatagramSize], '\n') + 1
+			if end == 0 {
+				return fmt.Errorf("line too long for udp datagram")
+			}
+		}
+		if _, err := cc.conn.Write(chunk[:end]); err != nil {
+			return err
+		}
+		chunk = chunk[end:]
+	}
+	return nil
+}
+
+// write sends the chunk and retries with a new connection until the context is canceled
+func (cc *carbonConnection) write(chunk []byte) error {
+	delay := time.Second
+	for {
+		var err error
+		if cc.conn == nil {
+			err = cc.connect()
+		}
+		if err == nil {
+			if err = cc.writeChunk(chunk); err == nil {
+				return nil
+			}
+			cc.conn.Close()
+			cc.conn = nil
+		}
+		logging.Log("carbon %s %s: %s, reconnecting in %s", cc.network, cc.address, err, delay)
+		select {
+		case <-cc.ctx.Done():
+			return cc.ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > carbonMaxReconnectDelay {
+			delay = carbonMaxReconnectDelay
+		}
+	}
+}
+
+func (cc *carbonConnection) close() error {
+	if cc.conn == nil {
+		return nil
+	}
+	err := cc.conn.Close()
+	cc.conn = nil
+	return err
+}
+
+// carbonChunk is queued data of the service key
+type carbonChunk struct {
+	key  string
+	data []byte
+}
+
+// carbonPending counts the queued chunks of a service, done is closed when all are sent
+type carbonPending struct {
+	count int
+	done  chan struct{}
+}
+
+// carbonWriter sends queued chunks over one or more carbon connections
+type carbonWriter struct {
+	chunks     chan *carbonChunk
+	wg         sync.WaitGroup
+	failed     chan struct{}
+	failedOnce sync.Once
+	err        error
+	mutex      sync.Mutex
+	pending    map[string]*carbonPending
+}
+
+func newCarbonWriter(ctx context.Context, network, address string, concurrency int) (*carbonWriter, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	conns := make([]*carbonConnection, concurrency)
+	for i := range conns {
+		conn, err := newCarbonConnection(ctx, network, address)
+		if err != nil {
+			for _, c := range conns[:i] {
+				c.close()
+			}
+			return nil, err
+		}
+		conns[i] = conn
+	}
+
+	cw := &carbonWriter{
+		chunks:  make(chan *carbonChunk, carbonQueueSize),
+		failed:  make(chan struct{}),
+		pending: make(map[string]*carbonPending),
+	}
+	for _, conn := range conns {
+		cw.wg.Add(1)
+		go cw.work(conn)
+	}
+	return cw, nil
+}
+
+func (cw *carbonWriter) work(conn *carbonConnection) {
+	defer cw.wg.Done()
+	defer conn.close()
+	for chunk := range cw.chunks {
+		if err := conn.write(chunk.data); err != nil {
+			cw.failedOnce.Do(func() {
+				cw.err = fmt.Errorf("could not send data to carbon: %s", err)
+				close(cw.failed)
+			})
+			return
+		}
+		cw.sent(chunk.key)
+	}
+}
+
+// push queues the chunk of the service key
+func (cw *carbonWriter) push(ctx context.Context, key string, data []byte) error {
+	cw.mutex.Lock()
+	pending := cw.pending[key]
+	if pending == nil {
+		pending = &carbonPending{done: make(chan struct{})}
+		cw.pending[key] = pending
+	}
+	pending.count++
+	cw.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		cw.sent(key)
+		return ctx.Err()
+	case <-cw.failed:
+		cw.sent(key)
+		return cw.err
+	case cw.chunks <- &carbonChunk{key: key, data: data}:
+		return nil
+	}
+}
+
+// sent marks a chunk of the service key as sent or dropped
+func (cw *carbonWriter) sent(key string) {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	pending := cw.pending[key]
+	if pending.count--; pending.count == 0 {
+		close(pending.done)
+		delete(cw.pending, key)
+	}
+}
+
+// flush waits until every chunk queued for the service key is sent
+func (cw *carbonWriter) flush(ctx context.Context, key string) error {
+	cw.mutex.Lock()
+	pending := cw.pending[key]
+	cw.mutex.Unlock()
+	if pending == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cw.failed:
+		return cw.err
+	case <-pending.done:
+		return nil
+	}
+}
+
+// close waits until all queued chunks are sent
+func (cw *carbonWriter) close() error {
+	close(cw.chunks)
+	cw.wg.Wait()
+	select {
+	case <-cw.failed:
+		return cw.err
+	default:
+		return nil
+	}
+}
+
+// sinkKey identifies the service of the metric, Sink.Flush waits for the data of one service
+func sinkKey(hostname, servicename string) string {
+	return hostname + "/" + servicename
+}
+
+func carbonMetricName(prefix string, metric *SinkMetric) string {
+	if prefix == "" {
+		return metric.Name
+	}
+	return prefix + "." + metric.Name
+}
+
+// CarbonSink sends the data with the carbon plaintext protocol
+type CarbonSink struct {
+	prefix    string
+	batchSize int
+	writer    *carbonWriter
+}
+
+// NewCarbonSink connects to carbon, network is either tcp or udp
+// Every metric name is prefixed with prefix if it is not empty.
+// Lines are sent in batches of batchSize, Send blocks if too many batches are pending.
+func NewCarbonSink(ctx context.Context, network, address, prefix string, batchSize int) (*CarbonSink, error) {
+	writer, err := newCarbonWriter(ctx, network, address, 1)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = sinkBatchSize
+	}
+	return &CarbonSink{
+		prefix:    prefix,
+		batchSize: batchSize,
+		writer:    writer,
+	}, nil
+}
+
+// Send queues the points as plaintext lines "<path> <value> <timestamp>"
+func (cs *CarbonSink) Send(ctx context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	name := carbonMetricName(cs.prefix, metric)
+	key := sinkKey(metric.Hostname, metric.Servicename)
+
+	var buf bytes.Buffer
+	lines := 0
+	for _, pt := range points {
+		buf.WriteString(name)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(pt.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(pt.Time))
+		buf.WriteByte('\n')
+		lines++
+		if lines == cs.batchSize {
+			if err := cs.writer.push(ctx, key, buf.Bytes()); err != nil {
+				return err
+			}
+			buf = bytes.Buffer{}
+			lines = 0
+		}
+	}
+	if lines > 0 {
+		return cs.writer.push(ctx, key, buf.Bytes())
+	}
+	return nil
+}
+
+// Flush waits until the queued data of the service is sent
+func (cs *CarbonSink) Flush(ctx context.Context, hostname, servicename string) error {
+	return cs.writer.flush(ctx, sinkKey(hostname, servicename))
+}
+
+// Close waits until all queued data is sent
+// Send must not be called after Close.
+func (cs *CarbonSink) Close() error {
+	return cs.writer.close()
+}
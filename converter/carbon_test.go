@@ -0,0 +1,147 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+func testSinkPoints(count int) []*whisper.TimeSeriesPoint {
+	points := make([]*whisper.TimeSeriesPoint, count)
+	for i := range points {
+		points[i] = &whisper.TimeSeriesPoint{Time: 1600000000 + i*60, Value: float64(i) / 2}
+	}
+	return points
+}
+
+func TestCarbonSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			lines <- nil
+			return
+		}
+		defer conn.Close()
+		result := make([]string, 0)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			result = append(result, scanner.Text())
+		}
+		lines <- result
+	}()
+
+	sink, err := NewCarbonSink(context.Background(), "tcp", ln.Addr().String(), "openitcockpit", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric := &SinkMetric{Name: "host1.service1.label1"}
+	if err := sink.Send(context.Background(), metric, testSinkPoints(20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var result []string
+	select {
+	case result = <-lines:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for carbon data")
+	}
+	if len(result) != 20 {
+		t.Fatalf("expected 20 lines, got %d", len(result))
+	}
+	if result[3] != "openitcockpit.host1.service1.label1 1.5 1600000180" {
+		t.Errorf("unexpected line \"%s\"", result[3])
+	}
+}
+
+func TestCarbonSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sink, err := NewCarbonSink(context.Background(), "udp", pc.LocalAddr().String(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric := &SinkMetric{Name: "host1.service1.label1"}
+	if err := sink.Send(context.Background(), metric, testSinkPoints(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := 0
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received < 100 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d lines: %s", received, err)
+		}
+		if n > carbonMaxDatagramSize {
+			t.Errorf("datagram too large: %d", n)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n") {
+			if !strings.HasPrefix(line, "host1.service1.label1 ") {
+				t.Errorf("unexpected line \"%s\"", line)
+			}
+			received++
+		}
+	}
+}
+
+func TestCarbonSinkInvalidProtocol(t *testing.T) {
+	if _, err := NewCarbonSink(context.Background(), "unix", "/tmp/carbon.sock", "", 0); err == nil {
+		t.Error("expected error for unsupported protocol")
+	}
+}
+
+func TestCarbonWriterFlush(t *testing.T) {
+	// without workers the chunks stay queued until they are taken from the channel
+	cw := &carbonWriter{
+		chunks:  make(chan *carbonChunk, carbonQueueSize),
+		failed:  make(chan struct{}),
+		pending: make(map[string]*carbonPending),
+	}
+	key := sinkKey("host1", "service1")
+	for i := 0; i < 2; i++ {
+		if err := cw.push(context.Background(), key, []byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.flush(context.Background(), sinkKey("host1", "service2")); err != nil {
+		t.Errorf("service without data must be flushed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cw.flush(ctx, key); err != context.DeadlineExceeded {
+		t.Fatalf("flush must wait for the queued chunks: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		cw.sent((<-cw.chunks).key)
+	}
+	if err := cw.flush(context.Background(), key); err != nil {
+		t.Error(err)
+	}
+	if len(cw.pending) != 0 {
+		t.Errorf("pending chunks left: %v", cw.pending)
+	}
+}
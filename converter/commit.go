@@ -0,0 +1,291 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+const commitFilename = "commit.json"
+
+// commitFile is a whisper file moved into the destination directory by a serviceCommit
+type commitFile struct {
+	Staged      string `json:"staged"`
+	Destination string `json:"destination"`
+	// Backup is where the replaced destination file is moved, empty if there was none
+	Backup string `json:"backup,omitempty"`
+	// Archive is set if Backup is in the archive path and kept after the commit
+	Archive bool `json:"archive,omitempty"`
+}
+
+// serviceCommit replaces the whisper files of one rrd set as a whole
+// The files are staged in a directory below the destination, so they are on the same
+// filesystem and can be renamed into place. The list of files is written to the staging
+// directory before the first destination file is touched, so a commit interrupted by a crash
+// is rolled back by the next conversion of the rrd set.
+type serviceCommit struct {
+	Files []*commitFile `json:"files"`
+
+	dir string
+}
+
+// stagingDir returns the staging directory of the rrd set
+func (cvt *Converter) stagingDir(rrdSet *rrdpath.RrdSet) string {
+	return filepath.Join(cvt.Destination, ".rrd2whisper-staging", rrdSet.Hostname, rrdSet.Servicename)
+}
+
+// stage moves the closed temporary whisper files into the staging directory dir
+// A commit left behind by a crashed run is rolled back first.
+func stage(dir string, groups [][]*convertSource) (*serviceCommit, error) {
+	if err := recoverCommit(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create staging directory: %s", err)
+	}
+
+	sc := &serviceCommit{dir: dir}
+	for _, sources := range groups {
+		for _, cs := range sources {
+			fl := &commitFile{
+				Staged:      filepath.Join(dir, cs.Label+".wsp"),
+				Destination: cs.DestinationFilename,
+			}
+			if _, err := os.Stat(cs.DestinationFilename); !os.IsNotExist(err) {
+				if cs.ArchiveFilename != "" {
+					fl.Backup = cs.ArchiveFilename
+					fl.Archive = true
+				} else {
+					fl.Backup = fl.Staged + ".old"
+				}
+			}
+			if err := moveFile(cs.TempFilename, fl.Staged); err != nil {
+				os.RemoveAll(dir)
+				return nil, fmt.Errorf("could not move wsp file to staging directory: %s", err)
+			}
+			sc.Files = append(sc.Files, fl)
+		}
+	}
+	if err := syncDir(dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return sc, nil
+}
+
+// commit moves the staged files into the destination directory
+// On failure the destination directory is restored and the staging directory removed.
+func (sc *serviceCommit) commit() error {
+	if err := sc.save(); err != nil {
+		os.RemoveAll(sc.dir)
+		return err
+	}
+	if err := sc.swap(); err != nil {
+		if rollbackErr := sc.rollback(); rollbackErr != nil {
+			logging.Log("%s", rollbackErr)
+			return fmt.Errorf("%s, %s", err, rollbackErr)
+		}
+		return err
+	}
+	synced := make(map[string]bool)
+	for _, fl := range sc.Files {
+		if destdir := filepath.Dir(fl.Destination); !synced[destdir] {
+			synced[destdir] = true
+			if err := syncDir(destdir); err != nil {
+				logging.Log("%s", err)
+			}
+		}
+	}
+	// the commit is complete once the file list is gone
+	if err := os.Remove(filepath.Join(sc.dir, commitFilename)); err != nil {
+		return fmt.Errorf("could not complete commit: %s", err)
+	}
+	if err := os.RemoveAll(sc.dir); err != nil {
+		logging.Log("could not remove staging directory: %s", err)
+	}
+	return nil
+}
+
+func (sc *serviceCommit) save() error {
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return fmt.Errorf("could not create commit file: %s", err)
+	}
+	filename := filepath.Join(sc.dir, commitFilename)
+	if err := writeFileSync(filename+".tmp", data); err != nil {
+		return fmt.Errorf("could not write commit file: %s", err)
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return fmt.Errorf("could not write commit file: %s", err)
+	}
+	return syncDir(sc.dir)
+}
+
+func (sc *serviceCommit) swap() error {
+	for _, fl := range sc.Files {
+		if err := os.MkdirAll(filepath.Dir(fl.Destination), 0755); err != nil {
+			return fmt.Errorf("could not create destination directory: %s", err)
+		}
+		if fl.Backup != "" {
+			if fl.Archive {
+				logging.Log("Move old whisper to archive \"%s\" -> \"%s\"", fl.Destination, fl.Backup)
+				if err := os.MkdirAll(filepath.Dir(fl.Backup), 0755); err != nil {
+					return fmt.Errorf("could not create directory for old whisper file archive: %s", err)
+				}
+			}
+			// the archive path may be on another filesystem
+			if err := moveFile(fl.Destination, fl.Backup); err != nil {
+				return fmt.Errorf("could not move old whisper file to archive: %s", err)
+			}
+		}
+		if err := os.Rename(fl.Staged, fl.Destination); err != nil {
+			return fmt.Errorf("could not move wsp file to destination directory: %s", err)
+		}
+	}
+	return nil
+}
+
+// rollback removes the moved files and restores the replaced ones
+// Every file is in one of three states: staged and the destination untouched, staged and the
+// destination moved to the backup, or moved to the destination.
+func (sc *serviceCommit) rollback() error {
+	for i := len(sc.Files) - 1; i >= 0; i-- {
+		fl := sc.Files[i]
+		if _, err := os.Stat(fl.Staged); os.IsNotExist(err) {
+			if err := os.Remove(fl.Destination); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("could not remove whisper file: %s", err)
+			}
+		}
+		if fl.Backup == "" {
+			continue
+		}
+		if _, err := os.Stat(fl.Destination); os.IsNotExist(err) {
+			logging.Log("Restore old whisper \"%s\" -> \"%s\"", fl.Backup, fl.Destination)
+			if err := moveFile(fl.Backup, fl.Destination); err != nil {
+				return fmt.Errorf("could not restore old whisper file: %s", err)
+			}
+		}
+	}
+	if err := os.RemoveAll(sc.dir); err != nil {
+		return fmt.Errorf("could not remove staging directory: %s", err)
+	}
+	return nil
+}
+
+// recoverCommit rolls back the commit of a crashed run and removes the staging directory
+func recoverCommit(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, commitFilename))
+	if os.IsNotExist(err) {
+		// staging was not completed, the destination is untouched
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not remove staging directory: %s", err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read commit file: %s", err)
+	}
+	sc := &serviceCommit{dir: dir}
+	if err := json.Unmarshal(data, sc); err != nil {
+		return fmt.Errorf("could not parse commit file %s: %s", filepath.Join(dir, commitFilename), err)
+	}
+	logging.Log("Rolling back interrupted commit in \"%s\"", dir)
+	return sc.rollback()
+}
+
+// renameFile is replaced in the tests to force the copy of moveFile
+var renameFile = os.Rename
+
+// moveFile renames src to dst or copies it if they are on different filesystems
+// The copy is written next to dst and renamed, so dst is never incomplete.
+func moveFile(src, dst string) error {
+	err := renameFile(src, dst)
+	if !isCrossDevice(err) {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func isCrossDevice(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	return ok && linkErr.Err == syscall.EXDEV
+}
+
+// CrossFilesystem checks if the paths are on different filesystems, so files can't be renamed
+// from one to the other
+func CrossFilesystem(a, b string) (bool, error) {
+	var statA, statB syscall.Stat_t
+	if err := syscall.Stat(a, &statA); err != nil {
+		return false, fmt.Errorf("could not stat %s: %s", a, err)
+	}
+	if err := syscall.Stat(b, &statB); err != nil {
+		return false, fmt.Errorf("could not stat %s: %s", b, err)
+	}
+	return statA.Dev != statB.Dev, nil
+}
+
+// copyFile copies src to dst and syncs dst to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeFileSync(filename string, data []byte) error {
+	fl, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fl.Write(data); err != nil {
+		fl.Close()
+		return err
+	}
+	if err := fl.Sync(); err != nil {
+		fl.Close()
+		return err
+	}
+	return fl.Close()
+}
+
+// syncDir writes the directory entries to disk, so renames survive a crash
+func syncDir(dir string) error {
+	fl, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open directory: %s", err)
+	}
+	defer fl.Close()
+	if err := fl.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory %s: %s", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, filename, content string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestFile(t *testing.T, filename, content string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("%s: %s", filename, err)
+	} else if string(data) != content {
+		t.Errorf("%s: expected \"%s\", got \"%s\"", filename, content, data)
+	}
+}
+
+func prepareCommit(t *testing.T, dir, archivedir string) (string, string, [][]*convertSource) {
+	tmpdir := filepath.Join(dir, "tmp")
+	destdir := filepath.Join(dir, "dest", "host1", "service1")
+	stagingdir := filepath.Join(dir, "dest", ".rrd2whisper-staging", "host1", "service1")
+	sources := make([]*convertSource, 0)
+	for _, label := range []string{"a", "b", "c"} {
+		cs := &convertSource{
+			Label:               label,
+			TempFilename:        filepath.Join(tmpdir, label+".wsp"),
+			DestinationFilename: filepath.Join(destdir, label+".wsp"),
+		}
+		if archivedir != "" {
+			cs.ArchiveFilename = filepath.Join(archivedir, label+".wsp")
+		}
+		writeTestFile(t, cs.TempFilename, "new "+label)
+		sources = append(sources, cs)
+	}
+	// c is a new metric
+	writeTestFile(t, sources[0].DestinationFilename, "old a")
+	writeTestFile(t, sources[1].DestinationFilename, "old b")
+	return destdir, stagingdir, [][]*convertSource{sources}
+}
+
+func TestServiceCommit(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "rrd2whisper-commit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		archivedir := ""
+		if archive {
+			archivedir = filepath.Join(dir, "archive")
+		}
+		destdir, stagingdir, groups := prepareCommit(t, dir, archivedir)
+
+		sc, err := stage(stagingdir, groups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTestFile(t, filepath.Join(destdir, "a.wsp"), "old a")
+		if err := sc.commit(); err != nil {
+			t.Fatal(err)
+		}
+		for _, label := range []string{"a", "b", "c"} {
+			checkTestFile(t, filepath.Join(destdir, label+".wsp"), "new "+label)
+		}
+		if archive {
+			checkTestFile(t, filepath.Join(archivedir, "a.wsp"), "old a")
+			checkTestFile(t, filepath.Join(archivedir, "b.wsp"), "old b")
+			if !sc.Files[0].Archive || sc.Files[2].Archive {
+				t.Error("only replaced files must be archived")
+			}
+		}
+		if _, err := os.Stat(stagingdir); !os.IsNotExist(err) {
+			t.Error("staging directory not removed")
+		}
+	}
+}
+
+func TestServiceCommitRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archivedir := filepath.Join(dir, "archive")
+	destdir, stagingdir, groups := prepareCommit(t, dir, archivedir)
+
+	sc, err := stage(stagingdir, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b can't be archived, so the commit fails after a was moved
+	if err := os.MkdirAll(filepath.Join(archivedir, "b.wsp", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.commit(); err == nil {
+		t.Fatal("commit must fail")
+	}
+	checkTestFile(t, filepath.Join(destdir, "a.wsp"), "old a")
+	checkTestFile(t, filepath.Join(destdir, "b.wsp"), "old b")
+	if _, err := os.Stat(filepath.Join(destdir, "c.wsp")); !os.IsNotExist(err) {
+		t.Error("new file not removed")
+	}
+	if _, err := os.Stat(stagingdir); !os.IsNotExist(err) {
+		t.Error("staging directory not removed")
+	}
+}
+
+func TestServiceCommitRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	destdir, stagingdir, groups := prepareCommit(t, dir, "")
+
+	sc, err := stage(stagingdir, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate a crash after a was replaced and b was moved away
+	if err := sc.save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fl := range sc.Files[:2] {
+		if err := os.Rename(fl.Destination, fl.Backup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(sc.Files[0].Staged, sc.Files[0].Destination); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recoverCommit(stagingdir); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(destdir, "a.wsp"), "old a")
+	checkTestFile(t, filepath.Join(destdir, "b.wsp"), "old b")
+	if _, err := os.Stat(filepath.Join(destdir, "c.wsp")); !os.IsNotExist(err) {
+		t.Error("new file must not exist")
+	}
+	if _, err := os.Stat(stagingdir); !os.IsNotExist(err) {
+		t.Error("staging directory not removed")
+	}
+}
+
+func TestCrossFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "a", "src.wsp"), "data")
+
+	if cross, err := CrossFilesystem(dir, filepath.Join(dir, "a")); err != nil || cross {
+		t.Errorf("same filesystem detected as cross filesystem: %s", err)
+	}
+	if _, err := CrossFilesystem(dir, filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing path must fail")
+	}
+	if !isCrossDevice(&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EXDEV}) || isCrossDevice(nil) {
+		t.Error("EXDEV not detected")
+	}
+
+	if err := copyFile(filepath.Join(dir, "a", "src.wsp"), filepath.Join(dir, "dst.wsp")); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(dir, "dst.wsp"), "data")
+	if err := moveFile(filepath.Join(dir, "a", "src.wsp"), filepath.Join(dir, "moved.wsp")); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(dir, "moved.wsp"), "data")
+}
+
+func TestCrossFilesystemCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a", "src.wsp")
+	dst := filepath.Join(dir, "b", "dst.wsp")
+	writeTestFile(t, src, "data")
+	writeTestFile(t, dst, "old")
+
+	renames := 0
+	renameFile = func(oldpath, newpath string) error {
+		renames++
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	defer func() { renameFile = os.Rename }()
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if renames != 1 {
+		t.Errorf("expected 1 rename, got %d", renames)
+	}
+	checkTestFile(t, dst, "data")
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source not removed after copy")
+	}
+	if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary copy not removed")
+	}
+}
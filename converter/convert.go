@@ -3,11 +3,9 @@ package converter
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"math"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-graphite/go-whisper"
@@ -17,17 +15,30 @@ import (
 	perfdata "github.com/jabdr/nagios-perfdata"
 )
 
-var illegalCharactersRegexp = regexp.MustCompile(`[^a-zA-Z^0-9\\-\\.]`)
+var illegalCharactersRegexp = regexp.MustCompile(`[^a-zA-Z0-9\-.]`)
 
 func replaceIllegalCharacters(s string) string {
 	return illegalCharactersRegexp.ReplaceAllString(s, "_")
 }
 
-var whisperRetention whisper.Retentions
+// AutoRetention can be passed to SetRetention to derive the whisper archives from each rrd file
+const AutoRetention = "auto"
+
+var (
+	whisperRetention whisper.Retentions
+	autoRetention    bool
+)
 
 // SetRetention must be called before first conversion
+// If retention is AutoRetention, the whisper archives are build from the AVERAGE rra's of each rrd file
 func SetRetention(retention string) error {
 	var err error
+	if retention == AutoRetention {
+		autoRetention = true
+		whisperRetention = nil
+		return nil
+	}
+	autoRetention = false
 	whisperRetention, err = whisper.ParseRetentionDefs(retention)
 	if err != nil {
 		return fmt.Errorf("could not parse whisper retention: %s", err)
@@ -35,14 +46,43 @@ func SetRetention(retention string) error {
 	return nil
 }
 
+// consolidation maps an rrd consolidation function to whisper files
+type consolidation struct {
+	Cf          string
+	Suffix      string
+	Aggregation whisper.AggregationMethod
+}
+
+var (
+	averageConsolidation = &consolidation{Cf: "AVERAGE", Suffix: "", Aggregation: whisper.Average}
+	minConsolidation     = &consolidation{Cf: "MIN", Suffix: ".min", Aggregation: whisper.Min}
+	maxConsolidation     = &consolidation{Cf: "MAX", Suffix: ".max", Aggregation: whisper.Max}
+)
+
 // Converter converts rrd files to whisper
 type Converter struct {
-	Merge          bool
-	DeleteRRD      bool
+	Merge bool
+	// MergeStrategy decides which values of an existing whisper file are kept, defaults to MergePreferRRD
+	MergeStrategy MergeStrategy
+	DeleteRRD     bool
+	// MinMax creates <label>.min.wsp and <label>.max.wsp from the MIN and MAX rra's
+	MinMax         bool
 	Destination    string
 	ArchivePath    string
 	TempPath       string
 	UUIDToPerfdata oitcdb.UUIDToPerfdata
+	// AggregationRules overwrite the aggregation method and xFilesFactor per metric
+	AggregationRules AggregationRules
+	// SchemaRules overwrite the retention per metric
+	SchemaRules SchemaRules
+	// Sink receives the data instead of whisper files if set
+	Sink Sink
+	// PathTemplate builds the metric names and the whisper paths if set
+	PathTemplate *PathTemplate
+	// Version is recorded in the journal
+	Version string
+	// ArchiveRun is a subdirectory of ArchivePath, so every run archives to its own directory
+	ArchiveRun string
 }
 
 func (cvt *Converter) checkPerfdata(servicename string) ([]string, error) {
@@ -65,28 +105,168 @@ func (cvt *Converter) checkPerfdata(servicename string) ([]string, error) {
 	return nil, nil
 }
 
+// resolveLabels replaces the datasource labels of the xml file with the labels of the perfdata in the database
+// Labels that would be written to the same whisper file are made unique, the renamed labels are
+// returned by their original name.
+func (cvt *Converter) resolveLabels(rrdSet *rrdpath.RrdSet) (map[string]string, error) {
+	dbLabels, err := cvt.checkPerfdata(rrdSet.Servicename)
+	if err != nil {
+		return nil, err
+	}
+	if dbLabels != nil {
+		if len(dbLabels) != len(rrdSet.Datasources) {
+			return nil, fmt.Errorf("invalid number of perfdata values db %d != xml %d", len(dbLabels), len(rrdSet.Datasources))
+		}
+		rrdSet.Datasources = dbLabels
+	}
+	return cvt.uniqueLabels(rrdSet)
+}
+
+// uniqueLabels appends the datasource number to labels that collide with an earlier label after
+// replacing the illegal characters, like 'disk /' and disk_
+func (cvt *Converter) uniqueLabels(rrdSet *rrdpath.RrdSet) (map[string]string, error) {
+	suffixes := []string{averageConsolidation.Suffix}
+	if cvt.MinMax {
+		suffixes = append(suffixes, minConsolidation.Suffix, maxConsolidation.Suffix)
+	}
+	var renamed map[string]string
+	labels := make([]string, len(rrdSet.Datasources))
+	used := make(map[string]int)
+	for i, label := range rrdSet.Datasources {
+		names, err := cvt.labelNames(rrdSet, i, label, suffixes)
+		if err != nil {
+			return nil, err
+		}
+		if j, collision := collides(used, names); collision {
+			newLabel := fmt.Sprintf("%s_%d", label, i+1)
+			if names, err = cvt.labelNames(rrdSet, i, newLabel, suffixes); err != nil {
+				return nil, err
+			}
+			if _, collision := collides(used, names); collision {
+				return nil, fmt.Errorf("datasources \"%s\" and \"%s\" are written to the same whisper file", labels[j], label)
+			}
+			logging.Log("%s: datasource \"%s\" collides with \"%s\" and is written as \"%s\"", rrdSet.RrdPath, label, labels[j], newLabel)
+			if renamed == nil {
+				renamed = make(map[string]string)
+			}
+			renamed[label] = newLabel
+			label = newLabel
+		}
+		for _, name := range names {
+			used[name] = i
+		}
+		labels[i] = label
+	}
+	rrdSet.Datasources = labels
+	return renamed, nil
+}
+
+// labelNames returns the metric names and the temporary filenames of label
+func (cvt *Converter) labelNames(rrdSet *rrdpath.RrdSet, index int, label string, suffixes []string) ([]string, error) {
+	names := make([]string, 0, 2*len(suffixes))
+	for _, suffix := range suffixes {
+		metric, err := cvt.metric(rrdSet, index, label, suffix)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, "metric:"+metric, "tmp:"+replaceIllegalCharacters(label+suffix))
+	}
+	return names, nil
+}
+
+// collides returns the index of the first datasource that uses one of the names
+func collides(used map[string]int, names []string) (int, bool) {
+	for _, name := range names {
+		if j, ok := used[name]; ok {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
 type convertSource struct {
 	Label               string
 	DestinationFilename string
 	TempFilename        string
 	ArchiveFilename     string
 	Whisper             *whisper.Whisper
+	// Retention of the whisper file as <seconds per point>:<points>,...
+	Retention string
+	// Points is the number of values written to the whisper file
+	Points int
+	// Merged is the resolved merge strategy if an existing whisper file was merged
+	Merged MergeStrategy
+}
+
+// metricName returns the graphite metric name of a whisper file relative to the destination
+func metricName(hostname, servicename, label string) string {
+	return fmt.Sprintf("%s.%s.%s", hostname, servicename, replaceIllegalCharacters(label))
+}
+
+// metric returns the metric name of the datasource at index, which is built by PathTemplate if set
+// suffix is the suffix of the consolidation, it is appended after the label was sanitized.
+func (cvt *Converter) metric(rrdSet *rrdpath.RrdSet, index int, label, suffix string) (string, error) {
+	if cvt.PathTemplate != nil {
+		metric, err := cvt.PathTemplate.Metric(rrdSet, index, label)
+		if err != nil {
+			return "", err
+		}
+		return metric + suffix, nil
+	}
+	return metricName(rrdSet.Hostname, rrdSet.Servicename, label+suffix), nil
+}
+
+// metricPaths are the names of the whisper file of one label
+type metricPaths struct {
+	Metric      string
+	Destination string
+	// Archive is empty if no archive path is set
+	Archive string
+}
+
+// metricPaths returns the metric name and the whisper filenames of the datasource at index
+// Without PathTemplate the files are stored in <destination>/<host>/<service>/<label><suffix>.wsp,
+// with PathTemplate the suffix of the consolidation is appended to the file of the template.
+func (cvt *Converter) metricPaths(rrdSet *rrdpath.RrdSet, index int, label, suffix string) (*metricPaths, error) {
+	if cvt.PathTemplate == nil {
+		destdir, archivedir := cvt.directories(rrdSet)
+		newLabel := replaceIllegalCharacters(label + suffix)
+		paths := &metricPaths{
+			Metric:      metricName(rrdSet.Hostname, rrdSet.Servicename, label+suffix),
+			Destination: fmt.Sprintf("%s/%s.wsp", destdir, newLabel),
+		}
+		if archivedir != "" {
+			paths.Archive = fmt.Sprintf("%s/%s.wsp", archivedir, newLabel)
+		}
+		return paths, nil
+	}
+
+	metric, err := cvt.PathTemplate.Metric(rrdSet, index, label)
+	if err != nil {
+		return nil, err
+	}
+	filename := strings.Replace(metric, ".", "/", -1) + suffix + ".wsp"
+	paths := &metricPaths{
+		Metric:      metric + suffix,
+		Destination: fmt.Sprintf("%s/%s", cvt.Destination, filename),
+	}
+	if cvt.ArchivePath != "" {
+		paths.Archive = fmt.Sprintf("%s/%s", cvt.archiveRoot(), filename)
+	}
+	return paths, nil
 }
 
-func newConvertSource(label, destdir, tmpdir, archivedir string) (*convertSource, error) {
+func newConvertSource(label, tmpdir string, paths *metricPaths, retention whisper.Retentions, aggregation whisper.AggregationMethod, xFilesFactor float32) (*convertSource, error) {
 	var err error
 	newLabel := replaceIllegalCharacters(label)
 	cs := convertSource{
 		Label:               newLabel,
 		TempFilename:        fmt.Sprintf("%s/%s.wsp", tmpdir, newLabel),
-		DestinationFilename: fmt.Sprintf("%s/%s.wsp", destdir, newLabel),
+		DestinationFilename: paths.Destination,
+		ArchiveFilename:     paths.Archive,
+		Retention:           retentionString(retention),
 	}
-	if archivedir == "" {
-		cs.ArchiveFilename = ""
-	} else {
-		cs.ArchiveFilename = fmt.Sprintf("%s/%s.wsp", archivedir, newLabel)
-	}
-	cs.Whisper, err = whisper.Create(cs.TempFilename, whisperRetention, whisper.Average, 0.5)
+	cs.Whisper, err = whisper.Create(cs.TempFilename, retention, aggregation, xFilesFactor)
 	if err != nil {
 		return nil, fmt.Errorf("could not create whisper file: %s", err)
 	}
@@ -94,148 +274,249 @@ func newConvertSource(label, destdir, tmpdir, archivedir string) (*convertSource
 	return &cs, nil
 }
 
-func (cs *convertSource) merge(lastUpdate int) error {
-	if _, err := os.Stat(cs.DestinationFilename); !os.IsNotExist(err) {
-		logging.Log("Merge whisper file \"%s\" with \"%s\"", cs.TempFilename, cs.DestinationFilename)
-		oldws, err := whisper.Open(cs.DestinationFilename)
-		if err != nil {
-			return fmt.Errorf("Could not open old whisper databaase: %s", err)
-		}
-		defer oldws.Close()
-		timeSeries, err := oldws.Fetch(lastUpdate, int(time.Now().Unix()))
-		if err != nil {
-			return fmt.Errorf("Could not fetch data from old whisper database: %s", err)
-		}
-		pts := timeSeries.PointPointers()
-		cleanPoints := make([]*whisper.TimeSeriesPoint, 0, len(pts))
-		for _, pt := range pts {
-			if !math.IsNaN(pt.Value) {
-				cleanPoints = append(cleanPoints, pt)
-			}
+// archiveMaxRetention returns the max retention of the archive with the precision secondsPerPoint
+// or -1 if the whisper file has no such archive
+func archiveMaxRetention(ws *whisper.Whisper, secondsPerPoint int) int {
+	for _, retention := range ws.Retentions() {
+		if retention.SecondsPerPoint() == secondsPerPoint {
+			return retention.MaxRetention()
 		}
-		if err = cs.Whisper.UpdateMany(cleanPoints); err != nil {
-			return fmt.Errorf("could not merge data from old whisper file: %s", err)
-		}
-		logging.Log("Successfully merged \"%s\"", cs.TempFilename)
 	}
-	return nil
+	return -1
 }
 
-func (cs *convertSource) archive() error {
-	if _, err := os.Stat(cs.DestinationFilename); !os.IsNotExist(err) {
-		if cs.ArchiveFilename != "" {
-			logging.Log("Move old whisper to archive \"%s\" -> \"%s\"", cs.DestinationFilename, cs.ArchiveFilename)
-			if err := os.MkdirAll(filepath.Dir(cs.ArchiveFilename), 0755); err != nil {
-				return fmt.Errorf("could not create directory for old whisper file archive: %s", err)
-			}
-			if err := os.Rename(cs.DestinationFilename, cs.ArchiveFilename); err != nil {
-				return fmt.Errorf("could not move old whisper file to archive: %s", err)
-			}
+// Convert an rrd file to whisper files
+// The result is recorded in the journal of the rrd set, canceled conversions are not recorded.
+func (cvt *Converter) Convert(ctx context.Context, rrdSet *rrdpath.RrdSet) error {
+	entry := &rrdpath.JournalEntry{
+		Started: time.Now(),
+		Version: cvt.Version,
+	}
+	err := cvt.convert(ctx, rrdSet, entry)
+	if err != nil && ctx.Err() == nil {
+		if journalErr := rrdSet.Failed(entry, err); journalErr != nil {
+			logging.Log("%s", journalErr)
 		}
 	}
-	return nil
+	return err
 }
 
-func (cs *convertSource) mergeAndArchive(lastUpdate int) error {
-	if err := cs.merge(lastUpdate); err != nil {
+func (cvt *Converter) convert(ctx context.Context, rrdSet *rrdpath.RrdSet, entry *rrdpath.JournalEntry) error {
+	renamed, err := cvt.resolveLabels(rrdSet)
+	if err != nil {
 		return err
 	}
+	entry.Renamed = renamed
 
-	if err := cs.archive(); err != nil {
-		return err
+	if cvt.Sink != nil {
+		return cvt.stream(ctx, rrdSet, entry)
 	}
 
-	return nil
-}
-
-// Convert an rrd file to whisper files
-func (cvt *Converter) Convert(ctx context.Context, rrdSet *rrdpath.RrdSet) error {
-	dbLabels, err := cvt.checkPerfdata(rrdSet.Servicename)
+	info, err := readRrdSetInfo(rrdSet)
 	if err != nil {
 		return err
 	}
-	if dbLabels != nil {
-		if len(dbLabels) != len(rrdSet.Datasources) {
-			return fmt.Errorf("invalid number of perfdata values db %d != xml %d", len(dbLabels), len(rrdSet.Datasources))
-		}
-		rrdSet.Datasources = dbLabels
-	}
 
-	destdir := fmt.Sprintf("%s/%s/%s", cvt.Destination, rrdSet.Hostname, rrdSet.Servicename)
-	archivedir := ""
-	if cvt.ArchivePath != "" {
-		archivedir = fmt.Sprintf("%s/%s/%s", cvt.ArchivePath, rrdSet.Hostname, rrdSet.Servicename)
-	}
-	tmpdir, err := ioutil.TempDir(cvt.TempPath, "rrd2whisper")
+	consolidations := cvt.consolidations(rrdSet, info)
+	settings, err := cvt.fileSettings(rrdSet, info, consolidations)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpdir)
 
-	dumperHelper, err := NewRrdDumperHelper(ctx, rrdSet.RrdPath)
+	// The temporary directory is kept with the progress if the conversion is canceled,
+	// so the next run can continue where this one stopped
+	tmpdir := cvt.resumeDir(rrdSet)
+	unlock, err := lockResumeDir(tmpdir)
 	if err != nil {
 		return err
 	}
-
-	sources := make([]*convertSource, len(rrdSet.Datasources))
-	for i, label := range rrdSet.Datasources {
-		sources[i], err = newConvertSource(label, destdir, tmpdir, archivedir)
-		if err != nil {
-			return err
-		}
+	defer unlock()
+	progress, err := loadProgress(tmpdir, rrdSet, info, consolidations, settings)
+	if err != nil {
+		return err
 	}
-	lastUpdate := sources[0].Whisper.StartTime()
-
-	cache := newTimeSeriesCache(sources, 100000)
-	for row := range dumperHelper.Results() {
-		ts := int(row.Time.Unix())
-		lastUpdate = ts
-		if err := cache.addRow(ts, row.Values); err != nil {
+	if progress.resume {
+		logging.Log("resuming conversion of %s", rrdSet.RrdPath)
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(tmpdir)
+		}
+	}()
+
+	groups := make([][]*convertSource, len(consolidations))
+	lastUpdates := make([]int, len(consolidations))
+	// interrupt closes the whisper files itself
+	closed := false
+	defer func() {
+		if !closed {
+			closeGroups(groups)
+		}
+	}()
+	for i, c := range consolidations {
+		groups[i], lastUpdates[i], err = cvt.convertConsolidation(ctx, rrdSet, info, c, tmpdir, progress, i)
+		if err != nil {
+			if ctx.Err() != nil {
+				closed = true
+				keep = cvt.interrupt(tmpdir, progress, groups[:i])
+			}
 			return err
 		}
-	}
-	if err := cache.flush(); err != nil {
-		return err
+		progress.LastUpdates[i] = lastUpdates[i]
 	}
 
 	// Check if canceld while dumping
 	select {
 	case <-ctx.Done():
+		progress.Consolidation = len(consolidations)
+		closed = true
+		keep = cvt.interrupt(tmpdir, progress, groups)
 		return ctx.Err()
 	default:
 	}
 
+	// Merging only reads the destination, so every file is merged before anything is moved
 	if cvt.Merge {
-		for _, source := range sources {
-			source.mergeAndArchive(lastUpdate)
+		for i, sources := range groups {
+			for _, source := range sources {
+				if err := source.merge(lastUpdates[i], cvt.MergeStrategy); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	closed = true
+	if err := closeGroups(groups); err != nil {
+		return err
+	}
+
+	// The files of the rrd set replace the destination files together or not at all
+	sc, err := stage(cvt.stagingDir(rrdSet), groups)
+	if err != nil {
+		return err
+	}
+	if err = sc.commit(); err != nil {
+		return err
+	}
+
+	i := 0
+	for _, sources := range groups {
+		for _, cs := range sources {
+			entry.Points += cs.Points
+			jf := rrdpath.JournalFile{
+				Path:      cs.DestinationFilename,
+				Retention: cs.Retention,
+				Points:    cs.Points,
+			}
+			if fl := sc.Files[i]; fl.Archive {
+				jf.Archive = fl.Backup
+			}
+			jf.Merged = string(cs.Merged)
+			entry.Files = append(entry.Files, jf)
+			i++
 		}
-	} else if cvt.ArchivePath != "" {
+	}
+
+	return cvt.finish(rrdSet, entry)
+}
+
+// retentionString formats the retentions as <seconds per point>:<points> like whisper.ParseRetentionDefs expects them
+func retentionString(retentions whisper.Retentions) string {
+	defs := make([]string, len(retentions))
+	for i, retention := range retentions {
+		defs[i] = fmt.Sprintf("%d:%d", retention.SecondsPerPoint(), retention.NumberOfPoints())
+	}
+	return strings.Join(defs, ",")
+}
+
+// closeGroups closes all whisper files and returns the first error
+// Sources that were not opened are skipped.
+func closeGroups(groups [][]*convertSource) error {
+	var err error
+	for _, sources := range groups {
 		for _, source := range sources {
-			source.archive()
+			if source == nil {
+				continue
+			}
+			if closeErr := source.Whisper.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
 		}
 	}
+	return err
+}
 
-	if err := cache.close(); err != nil {
-		return err
+// directories returns the destination directory and the archive directory of the rrd set
+// archivedir is empty if no archive path is set.
+func (cvt *Converter) directories(rrdSet *rrdpath.RrdSet) (destdir, archivedir string) {
+	destdir = fmt.Sprintf("%s/%s/%s", cvt.Destination, rrdSet.Hostname, rrdSet.Servicename)
+	if cvt.ArchivePath != "" {
+		archivedir = fmt.Sprintf("%s/%s/%s", cvt.archiveRoot(), rrdSet.Hostname, rrdSet.Servicename)
 	}
+	return destdir, archivedir
+}
 
-	if err = os.MkdirAll(destdir, 0755); err != nil {
-		return fmt.Errorf("could not create destination directory: %s", err)
+// archiveRoot is the directory of the files archived by this run
+// A second conversion of the same service must not overwrite the archive of the first one.
+func (cvt *Converter) archiveRoot() string {
+	if cvt.ArchiveRun == "" {
+		return cvt.ArchivePath
 	}
+	return fmt.Sprintf("%s/%s", cvt.ArchivePath, cvt.ArchiveRun)
+}
 
-	for _, cs := range sources {
-		if err = os.Rename(cs.TempFilename, cs.DestinationFilename); err != nil {
-			return fmt.Errorf("could not move wsp file to destination directory: %s", err)
+// consolidations returns the consolidations converted for the rrd file
+func (cvt *Converter) consolidations(rrdSet *rrdpath.RrdSet, info *rrdInfo) []*consolidation {
+	consolidations := []*consolidation{averageConsolidation}
+	if cvt.MinMax {
+		for _, c := range []*consolidation{minConsolidation, maxConsolidation} {
+			if len(info.archives(c.Cf)) == 0 {
+				logging.Log("rrd file %s has no %s rra", rrdSet.RrdPath, c.Cf)
+				continue
+			}
+			consolidations = append(consolidations, c)
 		}
 	}
+	return consolidations
+}
+
+// consolidationRetention returns the retention for the whisper files of the consolidation
+// before the storage schemas are applied
+func consolidationRetention(info *rrdInfo, c *consolidation) (whisper.Retentions, error) {
+	if autoRetention {
+		return info.retentions(c.Cf)
+	}
+	return whisperRetention, nil
+}
+
+// interrupt closes the whisper files of the canceled conversion and saves the progress
+// It returns false if the progress could not be saved.
+func (cvt *Converter) interrupt(tmpdir string, progress *convertProgress, groups [][]*convertSource) bool {
+	for _, sources := range groups {
+		progress.interrupt(sources)
+	}
+	if err := progress.save(tmpdir); err != nil {
+		logging.Log("%s", err)
+		return false
+	}
+	return true
+}
 
+// finish deletes the rrd file if requested and marks the rrd set as done
+func (cvt *Converter) finish(rrdSet *rrdpath.RrdSet, entry *rrdpath.JournalEntry) error {
 	var deleteError error = nil
 
 	if cvt.DeleteRRD {
-		deleteError = os.Remove(rrdSet.RrdPath)
+		for _, path := range rrdSet.RrdFiles {
+			if err := os.Remove(path); err != nil && deleteError == nil {
+				deleteError = err
+			}
+		}
 	}
 
-	err = rrdSet.Done()
+	err := rrdSet.Done(entry)
 	if err != nil {
 		return err
 	}
@@ -245,3 +526,118 @@ func (cvt *Converter) Convert(ctx context.Context, rrdSet *rrdpath.RrdSet) error
 
 	return nil
 }
+
+// convertConsolidation creates the whisper files for all datasources from the rra's of one consolidation function
+// It returns the sources and the time of the last row.
+// Consolidations before progress.Consolidation are opened from tmpdir, the current one is continued
+// after the last flushed row.
+func (cvt *Converter) convertConsolidation(ctx context.Context, rrdSet *rrdpath.RrdSet, info *rrdInfo, c *consolidation, tmpdir string, progress *convertProgress, index int) ([]*convertSource, int, error) {
+	var err error
+	rras := info.archives(c.Cf)
+	if len(rras) == 0 {
+		return nil, 0, fmt.Errorf("rrd file has no %s rra", c.Cf)
+	}
+
+	retention, err := consolidationRetention(info, c)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resume := progress.resume && index <= progress.Consolidation
+	sources := make([]*convertSource, len(rrdSet.Datasources))
+	// the opened whisper files are closed on errors, progress.interrupt closes them itself
+	closed := false
+	defer func() {
+		if !closed {
+			closeGroups([][]*convertSource{sources})
+		}
+	}()
+	for i, label := range rrdSet.Datasources {
+		paths, err := cvt.metricPaths(rrdSet, i, label, c.Suffix)
+		if err != nil {
+			return nil, 0, err
+		}
+		label += c.Suffix
+		aggregation, xFilesFactor := cvt.AggregationRules.Aggregation(paths.Metric, rrdSet.Unit(i), c.Aggregation, 0.5)
+		if resume {
+			sources[i], err = progress.openConvertSource(label, tmpdir, paths, cvt.SchemaRules.Retentions(paths.Metric, retention), aggregation, xFilesFactor)
+		} else {
+			sources[i], err = newConvertSource(label, tmpdir, paths, cvt.SchemaRules.Retentions(paths.Metric, retention), aggregation, xFilesFactor)
+			if err == nil {
+				progress.addSource(sources[i], aggregation, xFilesFactor)
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	lastUpdate := sources[0].Whisper.StartTime()
+	start := len(rras) - 1
+	skipUntil := 0
+	// A completed consolidation only gets the rows the rrd file was updated with since
+	catchUp := resume && index < progress.Consolidation
+	if catchUp {
+		lastUpdate = progress.LastUpdates[index]
+		if info.LastUpdate <= lastUpdate {
+			closed = true
+			return sources, lastUpdate, nil
+		}
+		start = 0
+		skipUntil = lastUpdate
+	} else if resume {
+		lastUpdate = progress.LastUpdates[index]
+		start = progress.Archive
+		skipUntil = progress.Time
+	}
+
+	// Walk the archives from coarse to fine, so the finer data overwrites
+	// the coarse data where both are available
+	cache := newTimeSeriesCache(sources, 100000)
+	for i := start; i >= 0; i-- {
+		dumperHelper, step, err := newRrdArchiveDumperHelper(ctx, rrdSet.RrdFiles, rras[i], info.LastUpdate)
+		if err != nil {
+			return nil, 0, err
+		}
+		cache.setStep(step)
+		cache.lastFlushed = 0
+		if i == start {
+			cache.lastFlushed = skipUntil
+		}
+		for row := range dumperHelper.Results() {
+			ts := int(row.Time.Unix())
+			if ts <= skipUntil && i == start {
+				continue
+			}
+			if ts > lastUpdate {
+				lastUpdate = ts
+			}
+			if err := cache.addRow(ts, row.Values); err != nil {
+				// drain the results so the dumper can finish
+				for range dumperHelper.Results() {
+				}
+				return nil, 0, err
+			}
+		}
+		if err := cache.flush(); err != nil {
+			return nil, 0, err
+		}
+
+		if ctx.Err() != nil {
+			if catchUp {
+				progress.LastUpdates[index] = cache.lastFlushed
+			} else {
+				progress.Consolidation = index
+				progress.Archive = i
+				progress.Time = cache.lastFlushed
+				progress.LastUpdates[index] = lastUpdate
+			}
+			closed = true
+			progress.interrupt(sources)
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	closed = true
+	return sources, lastUpdate, nil
+}
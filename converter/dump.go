@@ -3,32 +3,87 @@ package converter
 import (
 	"context"
 	"fmt"
-	"github.com/jabdr/rrd"
 	"math"
+	"time"
+
+	"github.com/jabdr/rrd"
 )
 
+// rrdRowSource is implemented by rrd.RrdDumper, rrdArchiveFetcher and rrdMultiSource
+type rrdRowSource interface {
+	Next() *rrd.RrdDumpRow
+	Free()
+}
+
+// joinSources returns the only source or joins the sources of multiple rrd files
+func joinSources(sources []rrdRowSource) rrdRowSource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return newRrdMultiSource(sources)
+}
+
+func freeSources(sources []rrdRowSource) {
+	for _, source := range sources {
+		source.Free()
+	}
+}
+
 // RrdDumperHelper wrapps arround rrd.RrdDumper to provide a cancable worker
 type RrdDumperHelper struct {
 	ctx     context.Context
-	dumper  *rrd.RrdDumper
+	dumper  rrdRowSource
 	results chan *rrd.RrdDumpRow
 }
 
 // NewRrdDumperHelper creates the background thread for rrd.RrdDumper
-func NewRrdDumperHelper(ctx context.Context, path string) (*RrdDumperHelper, error) {
-	var err error
+// The rows of multiple rrd files with one datasource each are joined by time.
+func NewRrdDumperHelper(ctx context.Context, paths ...string) (*RrdDumperHelper, error) {
+	sources := make([]rrdRowSource, 0, len(paths))
+	for _, path := range paths {
+		dumper, err := rrd.NewDumper(path, "AVERAGE")
+		if err != nil {
+			freeSources(sources)
+			return nil, fmt.Errorf("could not open rrd file: %s", err)
+		}
+		sources = append(sources, dumper)
+	}
+
+	return newRrdDumperHelper(ctx, joinSources(sources)), nil
+}
+
+// newRrdArchiveDumperHelper creates the background thread for a single rra of the rrd files
+// All rrd files must have the same archives.
+func newRrdArchiveDumperHelper(ctx context.Context, paths []string, rra *rrdArchive, lastUpdate int) (*RrdDumperHelper, int, error) {
+	sources := make([]rrdRowSource, 0, len(paths))
+	step := 0
+	for _, path := range paths {
+		fetcher, err := newRrdArchiveFetcher(path, rra, lastUpdate)
+		if err == nil && step != 0 && fetcher.step != step {
+			fetcher.Free()
+			err = fmt.Errorf("rrd fetch of %s returned step %d instead of %d", path, fetcher.step, step)
+		}
+		if err != nil {
+			freeSources(sources)
+			return nil, 0, err
+		}
+		step = fetcher.step
+		sources = append(sources, fetcher)
+	}
+
+	return newRrdDumperHelper(ctx, joinSources(sources)), step, nil
+}
+
+func newRrdDumperHelper(ctx context.Context, dumper rrdRowSource) *RrdDumperHelper {
 	rdh := &RrdDumperHelper{
 		ctx:     ctx,
+		dumper:  dumper,
 		results: make(chan *rrd.RrdDumpRow, 1000),
 	}
-	rdh.dumper, err = rrd.NewDumper(path, "AVERAGE")
-	if err != nil {
-		return nil, fmt.Errorf("could not open rrd file: %s", err)
-	}
 
 	go rdh.work()
 
-	return rdh, nil
+	return rdh
 }
 
 func (rdh *RrdDumperHelper) work() {
@@ -62,3 +117,107 @@ func (rdh *RrdDumperHelper) work() {
 func (rdh *RrdDumperHelper) Results() <-chan *rrd.RrdDumpRow {
 	return rdh.results
 }
+
+// rrdArchiveFetcher reads all rows of one rra with rrd_fetch
+// rrd.RrdDumper can only read the first rra of a consolidation function.
+type rrdArchiveFetcher struct {
+	start   int
+	step    int
+	columns int
+	rows    int
+	row     int
+	values  []float64
+}
+
+func newRrdArchiveFetcher(path string, rra *rrdArchive, lastUpdate int) (*rrdArchiveFetcher, error) {
+	end := lastUpdate - lastUpdate%rra.Step
+	start := end - rra.Step*rra.Rows
+	result, err := rrd.Fetch(path, rra.Cf, time.Unix(int64(start), 0), time.Unix(int64(end), 0), time.Duration(rra.Step)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s rra with step %d: %s", rra.Cf, rra.Step, err)
+	}
+	defer result.FreeValues()
+
+	raf := &rrdArchiveFetcher{
+		start:   int(result.Start.Unix()),
+		step:    int(result.Step.Seconds()),
+		columns: len(result.DsNames),
+	}
+	if raf.step <= 0 {
+		return nil, fmt.Errorf("rrd fetch returned invalid step %d", raf.step)
+	}
+	// rrd_fetch returns one value per column for each step after start until end
+	raf.rows = (int(result.End.Unix()) - raf.start) / raf.step
+	if raf.rows > result.RowCnt {
+		raf.rows = result.RowCnt
+	}
+	raf.values = result.Values()[:raf.rows*raf.columns]
+	return raf, nil
+}
+
+func (raf *rrdArchiveFetcher) Next() *rrd.RrdDumpRow {
+	if raf.row >= raf.rows {
+		return nil
+	}
+	offset := raf.row * raf.columns
+	row := &rrd.RrdDumpRow{
+		Time:   time.Unix(int64(raf.start+(raf.row+1)*raf.step), 0),
+		Values: raf.values[offset : offset+raf.columns],
+	}
+	raf.row++
+	return row
+}
+
+func (raf *rrdArchiveFetcher) Free() {
+	raf.values = nil
+}
+
+// rrdMultiSource joins the rows of the rrd files of a PNP4Nagios MULTIPLE rrd set by time
+// Every rrd file holds one datasource, values of rows missing in a file are NaN.
+type rrdMultiSource struct {
+	sources []rrdRowSource
+	rows    []*rrd.RrdDumpRow
+}
+
+func newRrdMultiSource(sources []rrdRowSource) *rrdMultiSource {
+	rms := &rrdMultiSource{
+		sources: sources,
+		rows:    make([]*rrd.RrdDumpRow, len(sources)),
+	}
+	for i, source := range sources {
+		rms.rows[i] = source.Next()
+	}
+	return rms
+}
+
+func (rms *rrdMultiSource) Next() *rrd.RrdDumpRow {
+	var next *rrd.RrdDumpRow
+	for _, row := range rms.rows {
+		if row != nil && (next == nil || row.Time.Before(next.Time)) {
+			next = row
+		}
+	}
+	if next == nil {
+		return nil
+	}
+	result := &rrd.RrdDumpRow{
+		Time:   next.Time,
+		Values: make([]float64, len(rms.rows)),
+	}
+	for i, row := range rms.rows {
+		result.Values[i] = math.NaN()
+		if row == nil || !row.Time.Equal(result.Time) {
+			continue
+		}
+		if len(row.Values) > 0 {
+			result.Values[i] = row.Values[0]
+		}
+		rms.rows[i] = rms.sources[i].Next()
+	}
+	return result
+}
+
+func (rms *rrdMultiSource) Free() {
+	freeSources(rms.sources)
+	rms.rows = nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"github.com/it-novum/rrd2whisper/testsuite"
 	"github.com/jabdr/nagios-perfdata"
+	"github.com/jabdr/rrd"
+	"math"
 	"testing"
 	"time"
 )
@@ -33,3 +35,97 @@ func TestRrdDumperHelperCancel(t *testing.T) {
 		t.Errorf("cancel did not work as expected: found %d results", counter)
 	}
 }
+
+func TestRrdArchiveDumperHelper(t *testing.T) {
+	ts := testsuite.Prepare()
+	defer ts.Shutdown()
+
+	pf, err := perfdata.ParsePerfdata("label1=0%;0;0;0; 'label2'=34")
+	if err != nil {
+		panic(err)
+	}
+	testData := testsuite.CreateRrd(ts.Source, "abc", "abc", pf, time.Now().Add(-testsuite.DAY), time.Now(), false)
+
+	info, err := readRrdInfo(testData.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rras := info.archives("AVERAGE")
+	if len(rras) != 1 {
+		t.Fatalf("expected 1 AVERAGE rra, found %d", len(rras))
+	}
+
+	dumper, step, err := newRrdArchiveDumperHelper(context.Background(), []string{testData.Path}, rras[0], info.LastUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != 60 {
+		t.Errorf("expected step 60, got %d", step)
+	}
+	counter := 0
+	for row := range dumper.Results() {
+		if len(row.Values) != 2 {
+			t.Fatalf("expected 2 values per row, got %d", len(row.Values))
+		}
+		counter++
+	}
+
+	// the last value is not consolidated before the next update
+	if counter < len(testData.TimeSeries)-2 {
+		t.Errorf("expected about %d rows, got %d", len(testData.TimeSeries), counter)
+	}
+}
+
+type testRowSource struct {
+	rows  []*rrd.RrdDumpRow
+	freed bool
+}
+
+func (trs *testRowSource) Next() *rrd.RrdDumpRow {
+	if len(trs.rows) == 0 {
+		return nil
+	}
+	row := trs.rows[0]
+	trs.rows = trs.rows[1:]
+	return row
+}
+
+func (trs *testRowSource) Free() {
+	trs.freed = true
+}
+
+func TestRrdMultiSource(t *testing.T) {
+	row := func(ts int64, value float64) *rrd.RrdDumpRow {
+		return &rrd.RrdDumpRow{Time: time.Unix(ts, 0), Values: []float64{value}}
+	}
+	sources := []*testRowSource{
+		{rows: []*rrd.RrdDumpRow{row(60, 1), row(120, 2), row(180, 3)}},
+		// updated later than the first file
+		{rows: []*rrd.RrdDumpRow{row(120, 20), row(180, 30), row(240, 40)}},
+	}
+	rms := newRrdMultiSource([]rrdRowSource{sources[0], sources[1]})
+
+	expected := [][3]float64{{60, 1, math.NaN()}, {120, 2, 20}, {180, 3, 30}, {240, math.NaN(), 40}}
+	for _, exp := range expected {
+		r := rms.Next()
+		if r == nil {
+			t.Fatalf("missing row %v", exp[0])
+		}
+		if r.Time.Unix() != int64(exp[0]) || len(r.Values) != 2 {
+			t.Fatalf("unexpected row %v", r)
+		}
+		for i, value := range r.Values {
+			if value != exp[i+1] && !(math.IsNaN(value) && math.IsNaN(exp[i+1])) {
+				t.Errorf("row %v: expected %v, got %v", exp[0], exp[1:], r.Values)
+				break
+			}
+		}
+	}
+	if r := rms.Next(); r != nil {
+		t.Errorf("unexpected row %v", r)
+	}
+	rms.Free()
+	if !sources[0].freed || !sources[1].freed {
+		t.Error("sources not freed")
+	}
+}
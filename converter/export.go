@@ -0,0 +1,156 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/it-novum/rrd2whisper/rrdpath"
+	"github.com/jabdr/rrd"
+)
+
+// RowWriter writes the rows of rrd files in an export format
+// A RowWriter is not safe for concurrent use.
+type RowWriter interface {
+	WriteRow(rrdSet *rrdpath.RrdSet, row *rrd.RrdDumpRow) error
+	// Flush writes all buffered rows to the underlying writer
+	Flush() error
+}
+
+// CSVRowWriter writes one line host,service,time,label,value per value of a row
+// Unknown values (NaN) are written as empty value.
+type CSVRowWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+// NewCSVRowWriter creates a RowWriter for csv
+func NewCSVRowWriter(w io.Writer) *CSVRowWriter {
+	return &CSVRowWriter{writer: csv.NewWriter(w)}
+}
+
+// WriteRow writes the values of the row
+func (crw *CSVRowWriter) WriteRow(rrdSet *rrdpath.RrdSet, row *rrd.RrdDumpRow) error {
+	if len(row.Values) != len(rrdSet.Datasources) {
+		return fmt.Errorf("invalid number of values in rrd %d != xml %d", len(row.Values), len(rrdSet.Datasources))
+	}
+	if !crw.header {
+		if err := crw.writer.Write([]string{"host", "service", "time", "label", "value"}); err != nil {
+			return fmt.Errorf("could not write csv: %s", err)
+		}
+		crw.header = true
+	}
+	ts := strconv.FormatInt(row.Time.Unix(), 10)
+	for i, value := range row.Values {
+		record := []string{rrdSet.Hostname, rrdSet.Servicename, ts, rrdSet.Datasources[i], ""}
+		if !math.IsNaN(value) {
+			record[4] = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+		if err := crw.writer.Write(record); err != nil {
+			return fmt.Errorf("could not write csv: %s", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered lines
+func (crw *CSVRowWriter) Flush() error {
+	crw.writer.Flush()
+	if err := crw.writer.Error(); err != nil {
+		return fmt.Errorf("could not write csv: %s", err)
+	}
+	return nil
+}
+
+// jsonRow is a single line of the newline-delimited json export
+type jsonRow struct {
+	Host    string              `json:"host"`
+	Service string              `json:"service"`
+	Time    int64               `json:"time"`
+	Values  map[string]*float64 `json:"values"`
+}
+
+// JSONRowWriter writes one json object per row
+// Unknown values (NaN) are written as null.
+type JSONRowWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONRowWriter creates a RowWriter for newline-delimited json
+func NewJSONRowWriter(w io.Writer) *JSONRowWriter {
+	writer := bufio.NewWriter(w)
+	return &JSONRowWriter{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+// WriteRow writes the row with all values mapped to there labels
+func (jrw *JSONRowWriter) WriteRow(rrdSet *rrdpath.RrdSet, row *rrd.RrdDumpRow) error {
+	if len(row.Values) != len(rrdSet.Datasources) {
+		return fmt.Errorf("invalid number of values in rrd %d != xml %d", len(row.Values), len(rrdSet.Datasources))
+	}
+	jr := &jsonRow{
+		Host:    rrdSet.Hostname,
+		Service: rrdSet.Servicename,
+		Time:    row.Time.Unix(),
+		Values:  make(map[string]*float64, len(row.Values)),
+	}
+	for i, value := range row.Values {
+		if math.IsNaN(value) {
+			jr.Values[rrdSet.Datasources[i]] = nil
+		} else {
+			v := value
+			jr.Values[rrdSet.Datasources[i]] = &v
+		}
+	}
+	if err := jrw.encoder.Encode(jr); err != nil {
+		return fmt.Errorf("could not write json: %s", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered lines
+func (jrw *JSONRowWriter) Flush() error {
+	if err := jrw.writer.Flush(); err != nil {
+		return fmt.Errorf("could not write json: %s", err)
+	}
+	return nil
+}
+
+// Export writes the rows of the rrd file with the resolved labels to rw
+// The rrd set is neither marked as done nor deleted.
+func (cvt *Converter) Export(ctx context.Context, rrdSet *rrdpath.RrdSet, rw RowWriter) error {
+	if _, err := cvt.resolveLabels(rrdSet); err != nil {
+		return err
+	}
+
+	dumperHelper, err := NewRrdDumperHelper(ctx, rrdSet.RrdFiles...)
+	if err != nil {
+		return err
+	}
+
+	for row := range dumperHelper.Results() {
+		if err := rw.WriteRow(rrdSet, row); err != nil {
+			// drain the results so the dumper can finish
+			for range dumperHelper.Results() {
+			}
+			return err
+		}
+	}
+
+	// Check if canceld while dumping
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return rw.Flush()
+}
@@ -0,0 +1,60 @@
+package converter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/rrdpath"
+	"github.com/jabdr/rrd"
+)
+
+var testExportRrdSet = &rrdpath.RrdSet{
+	Hostname:    "host1",
+	Servicename: "service1",
+	Datasources: []string{"rta", "pl,%"},
+}
+
+func testExportRows(t *testing.T, rw RowWriter) {
+	rows := []*rrd.RrdDumpRow{
+		{Time: time.Unix(1600000000, 0), Values: []float64{0.5, math.NaN()}},
+		{Time: time.Unix(1600000060, 0), Values: []float64{1, 20}},
+	}
+	for _, row := range rows {
+		if err := rw.WriteRow(testExportRrdSet, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.WriteRow(testExportRrdSet, &rrd.RrdDumpRow{Values: []float64{1}}); err == nil {
+		t.Error("expected error for invalid number of values")
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVRowWriter(t *testing.T) {
+	var buf bytes.Buffer
+	testExportRows(t, NewCSVRowWriter(&buf))
+	expected := `host,service,time,label,value
+host1,service1,1600000000,rta,0.5
+host1,service1,1600000000,"pl,%",
+host1,service1,1600000060,rta,1
+host1,service1,1600000060,"pl,%",20
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected csv:\n%s", buf.String())
+	}
+}
+
+func TestJSONRowWriter(t *testing.T) {
+	var buf bytes.Buffer
+	testExportRows(t, NewJSONRowWriter(&buf))
+	expected := `{"host":"host1","service":"service1","time":1600000000,"values":{"pl,%":null,"rta":0.5}}
+{"host":"host1","service":"service1","time":1600000060,"values":{"pl,%":20,"rta":1}}
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected json:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/logging"
+)
+
+const (
+	httpTimeout = 60 * time.Second
+	httpRetries = 5
+)
+
+// httpStatusError is returned if the server did not accept the request
+type httpStatusError struct {
+	status  int
+	message string
+}
+
+func (hse *httpStatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", hse.status, hse.message)
+}
+
+func parseHTTPURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme \"%s\"", u.Scheme)
+	}
+	return u, nil
+}
+
+// httpWriter posts request bodies to an http endpoint and retries on server and network errors
+type httpWriter struct {
+	name     string
+	url      string
+	username string
+	password string
+	header   http.Header
+	client   *http.Client
+}
+
+// newHTTPWriter creates a writer for u, the user info of u is used for basic auth
+func newHTTPWriter(name string, u *url.URL, header http.Header) *httpWriter {
+	hw := &httpWriter{
+		name:   name,
+		header: header,
+		client: &http.Client{Timeout: httpTimeout},
+	}
+	if u.User != nil {
+		hw.username = u.User.Username()
+		hw.password, _ = u.User.Password()
+		noUser := *u
+		noUser.User = nil
+		u = &noUser
+	}
+	hw.url = u.String()
+	return hw
+}
+
+func (hw *httpWriter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range hw.header {
+		req.Header[key] = values
+	}
+	if hw.username != "" {
+		req.SetBasicAuth(hw.username, hw.password)
+	}
+	resp, err := hw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &httpStatusError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// write posts the body and retries on server and network errors
+func (hw *httpWriter) write(ctx context.Context, body []byte) error {
+	var err error
+	delay := time.Second
+	for i := 0; i < httpRetries; i++ {
+		err = hw.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if hse, ok := err.(*httpStatusError); ok && hse.status < 500 {
+			// the request is invalid, retrying doesn't help
+			break
+		}
+		logging.Log("%s write failed: %s, retry in %s", hw.name, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("could not write to %s: %s", hw.name, err)
+}
@@ -0,0 +1,164 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+	influxTagEscaper         = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+)
+
+// influxLines encodes the points in influxdb line protocol with second precision
+// The measurement is the service, host, service and label are tags and the value is stored in the field value.
+// Infinite values can't be written in line protocol and are skipped.
+func influxLines(buf *bytes.Buffer, metric *SinkMetric, points []*whisper.TimeSeriesPoint) {
+	var series strings.Builder
+	series.WriteString(influxMeasurementEscaper.Replace(metric.Servicename))
+	series.WriteString(",host=")
+	series.WriteString(influxTagEscaper.Replace(metric.Hostname))
+	series.WriteString(",label=")
+	series.WriteString(influxTagEscaper.Replace(metric.Label))
+	series.WriteString(",service=")
+	series.WriteString(influxTagEscaper.Replace(metric.Servicename))
+	series.WriteString(" value=")
+	prefix := series.String()
+
+	for _, pt := range points {
+		if math.IsInf(pt.Value, 0) || math.IsNaN(pt.Value) {
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(strconv.FormatFloat(pt.Value, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(pt.Time))
+		buf.WriteByte('\n')
+	}
+}
+
+// InfluxFileSink writes the data in influxdb line protocol to a file
+type InfluxFileSink struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewInfluxFileSink creates or truncates the file at path
+func NewInfluxFileSink(path string) (*InfluxFileSink, error) {
+	fl, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not create influxdb output file: %s", err)
+	}
+	return &InfluxFileSink{
+		file:   fl,
+		writer: bufio.NewWriter(fl),
+	}, nil
+}
+
+// Send writes the points to the file
+func (ifs *InfluxFileSink) Send(_ context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	var buf bytes.Buffer
+	influxLines(&buf, metric, points)
+
+	ifs.mutex.Lock()
+	defer ifs.mutex.Unlock()
+	if _, err := ifs.writer.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write influxdb output file: %s", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered data to the file and syncs it to disk
+func (ifs *InfluxFileSink) Flush(_ context.Context, _, _ string) error {
+	ifs.mutex.Lock()
+	defer ifs.mutex.Unlock()
+	if err := ifs.writer.Flush(); err != nil {
+		return fmt.Errorf("could not write influxdb output file: %s", err)
+	}
+	if err := ifs.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync influxdb output file: %s", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the file
+func (ifs *InfluxFileSink) Close() error {
+	ifs.mutex.Lock()
+	defer ifs.mutex.Unlock()
+	if err := ifs.writer.Flush(); err != nil {
+		ifs.file.Close()
+		return fmt.Errorf("could not write influxdb output file: %s", err)
+	}
+	return ifs.file.Close()
+}
+
+// InfluxHTTPSink sends the data to the /write endpoint of influxdb
+type InfluxHTTPSink struct {
+	batchSize int
+	writer    *httpWriter
+}
+
+// NewInfluxHTTPSink creates a sink for the influxdb at baseURL (e.g. http://localhost:8086)
+// Credentials can be passed as user info of the url.
+func NewInfluxHTTPSink(baseURL, database string, batchSize int) (*InfluxHTTPSink, error) {
+	if database == "" {
+		return nil, fmt.Errorf("no influxdb database specified")
+	}
+	u, err := parseHTTPURL(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid influxdb url: %s", err)
+	}
+	if batchSize <= 0 {
+		batchSize = sinkBatchSize
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+	query := u.Query()
+	query.Set("db", database)
+	query.Set("precision", "s")
+	u.RawQuery = query.Encode()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &InfluxHTTPSink{
+		batchSize: batchSize,
+		writer:    newHTTPWriter("influxdb", u, header),
+	}, nil
+}
+
+// Send posts the points in batches of batchSize lines
+func (ihs *InfluxHTTPSink) Send(ctx context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	for len(points) > 0 {
+		end := len(points)
+		if end > ihs.batchSize {
+			end = ihs.batchSize
+		}
+		var buf bytes.Buffer
+		influxLines(&buf, metric, points[:end])
+		if err := ihs.writer.write(ctx, buf.Bytes()); err != nil {
+			return err
+		}
+		points = points[end:]
+	}
+	return nil
+}
+
+// Flush does nothing, every Send is written immediately
+func (ihs *InfluxHTTPSink) Flush(context.Context, string, string) error {
+	return nil
+}
+
+// Close does nothing, every Send is written immediately
+func (ihs *InfluxHTTPSink) Close() error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+var testInfluxMetric = &SinkMetric{
+	Name:        "host1.service_1.disk_",
+	Hostname:    "host1",
+	Servicename: "service 1",
+	Label:       "disk_",
+}
+
+func TestInfluxFileSink(t *testing.T) {
+	fl, err := ioutil.TempFile("", "rrd2whisper-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	defer os.Remove(fl.Name())
+
+	sink, err := NewInfluxFileSink(fl.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// infinite values are invalid in line protocol
+	points := append(testSinkPoints(3), &whisper.TimeSeriesPoint{Time: 1600000180, Value: math.Inf(1)})
+	if err := sink.Send(context.Background(), testInfluxMetric, points); err != nil {
+		t.Fatal(err)
+	}
+	// the data is in the file before the sink is closed
+	if err := sink.Flush(context.Background(), testInfluxMetric.Hostname, testInfluxMetric.Servicename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fl.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %v", len(lines), lines)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines[1] != `service\ 1,host=host1,label=disk_,service=service\ 1 value=0.5 1600000060` {
+		t.Errorf("unexpected line \"%s\"", lines[1])
+	}
+}
+
+func TestInfluxHTTPSink(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/write" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("db") != "perfdata" || r.URL.Query().Get("precision") != "s" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			t.Errorf("missing basic auth")
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if lines := strings.Count(string(body), "\n"); lines > 4 {
+			t.Errorf("batch too large: %d lines", lines)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxHTTPSink(strings.Replace(server.URL, "http://", "http://admin:secret@", 1), "perfdata", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testInfluxMetric, testSinkPoints(10)); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestInfluxHTTPSinkBadRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxHTTPSink(server.URL, "perfdata", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testInfluxMetric, testSinkPoints(1)); err == nil {
+		t.Error("expected error for status 404")
+	}
+}
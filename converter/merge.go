@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/logging"
+)
+
+// MergeStrategy decides which values of an existing whisper file are carried into the converted file
+type MergeStrategy string
+
+// Merge strategies
+const (
+	// MergePreferRRD keeps only the values of the existing whisper file that are newer than the last rrd row
+	MergePreferRRD MergeStrategy = "prefer-rrd"
+	// MergePreferWhisper overwrites the rrd data with every value of the existing whisper file
+	MergePreferWhisper MergeStrategy = "prefer-whisper"
+	// MergeFillGaps uses the values of the existing whisper file only where the rrd has no value
+	MergeFillGaps MergeStrategy = "fill-gaps"
+	// MergeNewestWins prefers the existing whisper file if it has newer values than the rrd file,
+	// otherwise it only fills the gaps of the rrd data
+	MergeNewestWins MergeStrategy = "newest-wins"
+)
+
+// ParseMergeStrategy checks the name of the merge strategy
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	switch strategy := MergeStrategy(name); strategy {
+	case MergePreferRRD, MergePreferWhisper, MergeFillGaps, MergeNewestWins:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("invalid merge strategy \"%s\"", name)
+}
+
+// valueAt returns the value of the interval containing ts or NaN
+func valueAt(ts *whisper.TimeSeries, t int) float64 {
+	if ts == nil || t < ts.FromTime() || t >= ts.UntilTime() {
+		return math.NaN()
+	}
+	return ts.Values()[(t-ts.FromTime())/ts.Step()]
+}
+
+// newestPoint returns the time of the newest value in the series or 0
+func newestPoint(series *whisper.TimeSeries) int {
+	newest := 0
+	if series != nil {
+		for _, pt := range series.PointPointers() {
+			if !math.IsNaN(pt.Value) {
+				newest = pt.Time
+			}
+		}
+	}
+	return newest
+}
+
+// resolve replaces MergeNewestWins with the strategy for the newest value of the old whisper file
+func (strategy MergeStrategy) resolve(newest, lastUpdate int) MergeStrategy {
+	if strategy != MergeNewestWins {
+		return strategy
+	} else if newest > lastUpdate {
+		return MergePreferWhisper
+	}
+	return MergeFillGaps
+}
+
+// mergePoints selects the points of the old whisper file that are written to the new one
+// MergeNewestWins must be resolved before.
+func (strategy MergeStrategy) mergePoints(oldSeries, newSeries *whisper.TimeSeries, lastUpdate, before int) []*whisper.TimeSeriesPoint {
+	points := make([]*whisper.TimeSeriesPoint, 0)
+	for _, pt := range oldSeries.PointPointers() {
+		if math.IsNaN(pt.Value) || pt.Time >= before {
+			continue
+		}
+		switch strategy {
+		case MergePreferWhisper:
+			points = append(points, pt)
+		case MergeFillGaps:
+			if math.IsNaN(valueAt(newSeries, pt.Time)) {
+				points = append(points, pt)
+			}
+		default:
+			if pt.Time > lastUpdate {
+				points = append(points, pt)
+			}
+		}
+	}
+	return points
+}
+
+// merge carries the values of every archive of the existing destination file into the
+// archive with the same precision of the new whisper file
+func (cs *convertSource) merge(lastUpdate int, strategy MergeStrategy) error {
+	if _, err := os.Stat(cs.DestinationFilename); !os.IsNotExist(err) {
+		logging.Log("Merge whisper file \"%s\" with \"%s\" (%s)", cs.TempFilename, cs.DestinationFilename, strategy)
+		oldws, err := whisper.Open(cs.DestinationFilename)
+		if err != nil {
+			return fmt.Errorf("Could not open old whisper databaase: %s", err)
+		}
+		defer oldws.Close()
+
+		now := int(time.Now().Unix())
+		retentions := oldws.Retentions()
+		series := make([]*whisper.TimeSeries, len(retentions))
+		for i, retention := range retentions {
+			// from selects the archive, the finer archives have a shorter retention
+			if series[i], err = oldws.Fetch(now-retention.MaxRetention(), now); err != nil {
+				return fmt.Errorf("Could not fetch data from old whisper database: %s", err)
+			}
+		}
+		strategy = strategy.resolve(newestPoint(series[0]), lastUpdate)
+		cs.Merged = strategy
+
+		// Walk the archives from coarse to fine like the conversion, so the finer data overwrites
+		// the coarse data where both are available
+		for i := len(retentions) - 1; i >= 0; i-- {
+			if series[i] == nil {
+				continue
+			}
+			newSeries, err := cs.Whisper.Fetch(now-retentions[i].MaxRetention(), now)
+			if err != nil {
+				return fmt.Errorf("could not fetch data from new whisper file: %s", err)
+			}
+			targetRetention := archiveMaxRetention(cs.Whisper, retentions[i].SecondsPerPoint())
+			before := now + 1
+			if targetRetention == -1 && i > 0 {
+				// without a matching archive only the points that are not in the finer archives are kept
+				before = now - retentions[i-1].MaxRetention()
+			}
+			points := strategy.mergePoints(series[i], newSeries, lastUpdate, before)
+			if err = cs.Whisper.UpdateManyForArchive(points, targetRetention); err != nil {
+				return fmt.Errorf("could not merge data from old whisper file: %s", err)
+			}
+		}
+		logging.Log("Successfully merged \"%s\"", cs.TempFilename)
+	}
+	return nil
+}
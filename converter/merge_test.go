@@ -0,0 +1,134 @@
+package converter
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+// testMerge merges an old file with values at -5m..-1m into a new file with values at -8m..-3m,
+// the rrd value at -4m is unknown. The resolved strategy is returned with the values.
+func testMerge(t *testing.T, dir string, strategy MergeStrategy) ([]float64, MergeStrategy) {
+	retention, _ := whisper.ParseRetentionDefs("60s:1h")
+	now := int(time.Now().Unix())
+	now -= now % 60
+
+	dest := filepath.Join(dir, string(strategy)+"-old.wsp")
+	oldws, err := whisper.Create(dest, retention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i > 0; i-- {
+		oldws.Update(100+float64(i), now-i*60)
+	}
+	oldws.Close()
+
+	cs, err := newConvertSource(string(strategy), dir, &metricPaths{Destination: dest}, retention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Whisper.Close()
+	for i := 8; i >= 3; i-- {
+		if i != 4 {
+			cs.Whisper.Update(float64(i), now-i*60)
+		}
+	}
+
+	if err := cs.merge(now-3*60, strategy); err != nil {
+		t.Fatal(err)
+	}
+	series, err := cs.Whisper.Fetch(now-9*60, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return series.Values(), cs.Merged
+}
+
+func TestMergeStrategies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nan := math.NaN()
+	expected := map[MergeStrategy][]float64{
+		// -8m -7m -6m -5m -4m -3m -2m -1m
+		MergePreferRRD:     {8, 7, 6, 5, nan, 3, 102, 101},
+		MergePreferWhisper: {8, 7, 6, 105, 104, 103, 102, 101},
+		MergeFillGaps:      {8, 7, 6, 5, 104, 3, 102, 101},
+		MergeNewestWins:    {8, 7, 6, 105, 104, 103, 102, 101},
+	}
+	for strategy, values := range expected {
+		result, merged := testMerge(t, dir, strategy)
+		if resolved := strategy.resolve(1, 0); merged != resolved {
+			t.Errorf("%s: expected merged %s, got %s", strategy, resolved, merged)
+		}
+		// the interval of now is included
+		if len(result) < len(values) {
+			t.Fatalf("%s: expected %d values, got %v", strategy, len(values), result)
+		}
+		for i := range values {
+			if !(result[i] == values[i] || math.IsNaN(result[i]) && math.IsNaN(values[i])) {
+				t.Errorf("%s: expected %v, got %v", strategy, values, result)
+				break
+			}
+		}
+	}
+
+	if _, err := ParseMergeStrategy("oldest-wins"); err == nil {
+		t.Error("expected error for invalid strategy")
+	}
+}
+
+func TestMergeAllArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := int(time.Now().Unix())
+	now -= now % 300
+	oldRetention, _ := whisper.ParseRetentionDefs("60s:1h,300s:1d")
+	dest := filepath.Join(dir, "old.wsp")
+	oldws, err := whisper.Create(dest, oldRetention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only in the coarse archive
+	oldws.Update(50, now-5*3600)
+	// in both archives
+	oldws.Update(10, now-600)
+	oldws.Close()
+
+	for _, def := range []string{"60s:1h,300s:1d", "60s:1d"} {
+		retention, _ := whisper.ParseRetentionDefs(def)
+		cs, err := newConvertSource(def, dir, &metricPaths{Destination: dest}, retention, whisper.Average, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.merge(now-24*3600, MergePreferRRD); err != nil {
+			t.Fatal(err)
+		}
+		series, err := cs.Whisper.Fetch(now-24*3600+300, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := valueAt(series, now-5*3600); value != 50 {
+			t.Errorf("%s: value of the coarse archive not merged, got %v", def, value)
+		}
+		if series, err = cs.Whisper.Fetch(now-1800, now); err != nil {
+			t.Fatal(err)
+		}
+		if value := valueAt(series, now-600); value != 10 {
+			t.Errorf("%s: expected 10, got %v", def, value)
+		}
+		cs.Whisper.Close()
+	}
+}
@@ -0,0 +1,135 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/it-novum/rrd2whisper/oitcdb"
+)
+
+// Naming maps the host and service uuids to path components built from their display names
+// Names that are equal after replacing the illegal characters get the beginning of the uuid
+// appended, so every host and every service of a host has a distinct path.
+// Once a uuid is mapped, its path component never changes, so the whisper files of later
+// runs are written next to the existing ones.
+type Naming struct {
+	Hosts    map[string]string `json:"hosts"`
+	Services map[string]string `json:"services"`
+}
+
+// NewNaming builds the path components from the display names
+// The entries of existing are kept, only uuids that are not mapped yet get new components.
+// existing may be nil.
+func NewNaming(names *oitcdb.Names, sanitizer *Sanitizer, existing *Naming) *Naming {
+	naming := &Naming{
+		Hosts:    make(map[string]string),
+		Services: make(map[string]string),
+	}
+	if existing == nil {
+		existing = new(Naming)
+	}
+	hosts := make(map[string]string, len(names.Hosts))
+	for uuid, name := range names.Hosts {
+		hosts[uuid] = sanitizer.Component(name)
+	}
+	disambiguate(hosts, func(uuid string) string { return "" }, sanitizer, existing.Hosts, naming.Hosts)
+
+	services := make(map[string]string, len(names.Services))
+	for uuid, service := range names.Services {
+		services[uuid] = sanitizer.Component(service.Name)
+	}
+	// service names only have to be unique per host
+	serviceScope := func(uuid string) string {
+		if service := names.Services[uuid]; service != nil {
+			return service.HostUUID
+		}
+		return ""
+	}
+	disambiguate(services, serviceScope, sanitizer, existing.Services, naming.Services)
+	return naming
+}
+
+// disambiguate copies existing and the components of the other uuids to result and appends the
+// uuid to new components that are used more than once within the same scope
+func disambiguate(components map[string]string, scope func(uuid string) string, sanitizer *Sanitizer, existing, result map[string]string) {
+	used := make(map[string]bool, len(existing))
+	for uuid, component := range existing {
+		result[uuid] = component
+		used[scope(uuid)+"/"+component] = true
+	}
+	uuids := make(map[string][]string)
+	for uuid, component := range components {
+		if _, ok := existing[uuid]; ok {
+			continue
+		}
+		key := scope(uuid) + "/" + component
+		uuids[key] = append(uuids[key], uuid)
+	}
+	for key, list := range uuids {
+		if len(list) == 1 && !used[key] {
+			result[list[0]] = components[list[0]]
+			if result[list[0]] == "" {
+				result[list[0]] = sanitizer.Component(list[0])
+			}
+			continue
+		}
+		// sorted, so the suffix length doesn't depend on the order of the database rows
+		sort.Strings(list)
+		length := 8
+		for !uniquePrefixes(list, length) {
+			length++
+		}
+		for _, uuid := range list {
+			result[uuid] = sanitizer.withSuffix(components[uuid], prefix(uuid, length))
+		}
+	}
+}
+
+func uniquePrefixes(sorted []string, length int) bool {
+	for i := 1; i < len(sorted); i++ {
+		if prefix(sorted[i], length) == prefix(sorted[i-1], length) {
+			return false
+		}
+	}
+	return true
+}
+
+func prefix(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
+
+// LoadNaming reads the mapping file written by Save
+func LoadNaming(path string) (*Naming, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read name mapping file: %s", err)
+	}
+	naming := new(Naming)
+	if err := json.Unmarshal(data, naming); err != nil {
+		return nil, fmt.Errorf("could not parse name mapping file: %s", err)
+	}
+	if naming.Hosts == nil {
+		naming.Hosts = make(map[string]string)
+	}
+	if naming.Services == nil {
+		naming.Services = make(map[string]string)
+	}
+	return naming, nil
+}
+
+// Save writes the mapping of uuids to path components
+func (naming *Naming) Save(path string) error {
+	data, err := json.MarshalIndent(naming, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not create name mapping file: %s", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write name mapping file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/it-novum/rrd2whisper/oitcdb"
+)
+
+func TestNaming(t *testing.T) {
+	names := &oitcdb.Names{
+		Hosts: map[string]string{
+			"c36b8048-93ce-4385-ac19-ab5c90574b77": "web.example.com",
+			"1a2b3c4d-0000-4385-ac19-ab5c90574b77": "db 1",
+			"1a2b3c4d-1111-4385-ac19-ab5c90574b77": "db_1",
+		},
+		Services: map[string]*oitcdb.ServiceName{
+			"74fd8f59-1348-4e16-85f0-4a5c57c7dd62": {HostUUID: "c36b8048-93ce-4385-ac19-ab5c90574b77", Name: "Ping"},
+			"b9a1ef6a-a3b4-4f1c-9f2e-0c5b1c0b7a11": {HostUUID: "1a2b3c4d-0000-4385-ac19-ab5c90574b77", Name: "Ping"},
+			"1c8e6f0a-7a5c-4d09-8b8f-6e3b2a9d4c22": {HostUUID: "c36b8048-93ce-4385-ac19-ab5c90574b77", Name: "Disk /"},
+			"2d9f7a1b-8b6d-4e1a-9c90-7f4c3bae5d33": {HostUUID: "c36b8048-93ce-4385-ac19-ab5c90574b77", Name: "Disk_/"},
+		},
+	}
+	naming := NewNaming(names, DefaultSanitizer(), nil)
+
+	expected := map[string]string{
+		"c36b8048-93ce-4385-ac19-ab5c90574b77": "web_example_com",
+		// both names are db_1 after replacing the illegal characters
+		"1a2b3c4d-0000-4385-ac19-ab5c90574b77": "db_1_1a2b3c4d-0",
+		"1a2b3c4d-1111-4385-ac19-ab5c90574b77": "db_1_1a2b3c4d-1",
+	}
+	for uuid, name := range expected {
+		if naming.Hosts[uuid] != name {
+			t.Errorf("host %s: expected %s, got %s", uuid, name, naming.Hosts[uuid])
+		}
+	}
+	expected = map[string]string{
+		// the same name on different hosts is no collision
+		"74fd8f59-1348-4e16-85f0-4a5c57c7dd62": "Ping",
+		"b9a1ef6a-a3b4-4f1c-9f2e-0c5b1c0b7a11": "Ping",
+		"1c8e6f0a-7a5c-4d09-8b8f-6e3b2a9d4c22": "Disk___1c8e6f0a",
+		"2d9f7a1b-8b6d-4e1a-9c90-7f4c3bae5d33": "Disk___2d9f7a1b",
+	}
+	for uuid, name := range expected {
+		if naming.Services[uuid] != name {
+			t.Errorf("service %s: expected %s, got %s", uuid, name, naming.Services[uuid])
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "rrd2whisper-naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mappingFile := filepath.Join(dir, "names.json")
+	if err := naming.Save(mappingFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNaming(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, naming) {
+		t.Errorf("loaded mapping differs: %+v", loaded)
+	}
+
+	// the suffix is kept within the max length
+	sanitizer, err := NewSanitizer("a-z0-9_", "_", true, 12, DotsReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	naming = NewNaming(names, sanitizer, nil)
+	if name := naming.Hosts["1a2b3c4d-0000-4385-ac19-ab5c90574b77"]; name != "d_1a2b3c4d_0" {
+		t.Errorf("unexpected name %s", name)
+	}
+}
+
+func TestNamingKeepsExisting(t *testing.T) {
+	names := &oitcdb.Names{
+		Hosts: map[string]string{"c36b8048-93ce-4385-ac19-ab5c90574b77": "web"},
+		Services: map[string]*oitcdb.ServiceName{
+			"74fd8f59-1348-4e16-85f0-4a5c57c7dd62": {HostUUID: "c36b8048-93ce-4385-ac19-ab5c90574b77", Name: "Ping"},
+		},
+	}
+	first := NewNaming(names, DefaultSanitizer(), nil)
+	if first.Hosts["c36b8048-93ce-4385-ac19-ab5c90574b77"] != "web" {
+		t.Fatalf("unexpected mapping %+v", first)
+	}
+
+	// a second host and service with the same names are added later
+	names.Hosts["1a2b3c4d-0000-4385-ac19-ab5c90574b77"] = "web"
+	names.Hosts["9f8e7d6c-0000-4385-ac19-ab5c90574b77"] = "db"
+	names.Services["b9a1ef6a-a3b4-4f1c-9f2e-0c5b1c0b7a11"] = &oitcdb.ServiceName{HostUUID: "c36b8048-93ce-4385-ac19-ab5c90574b77", Name: "Ping"}
+	second := NewNaming(names, DefaultSanitizer(), first)
+
+	expected := map[string]string{
+		"c36b8048-93ce-4385-ac19-ab5c90574b77": "web",
+		"1a2b3c4d-0000-4385-ac19-ab5c90574b77": "web_1a2b3c4d",
+		"9f8e7d6c-0000-4385-ac19-ab5c90574b77": "db",
+	}
+	if !reflect.DeepEqual(second.Hosts, expected) {
+		t.Errorf("expected hosts %v, got %v", expected, second.Hosts)
+	}
+	expected = map[string]string{
+		"74fd8f59-1348-4e16-85f0-4a5c57c7dd62": "Ping",
+		"b9a1ef6a-a3b4-4f1c-9f2e-0c5b1c0b7a11": "Ping_b9a1ef6a",
+	}
+	if !reflect.DeepEqual(second.Services, expected) {
+		t.Errorf("expected services %v, got %v", expected, second.Services)
+	}
+
+	// hosts removed from the database keep their mapping for verify and rollback
+	delete(names.Hosts, "c36b8048-93ce-4385-ac19-ab5c90574b77")
+	if third := NewNaming(names, DefaultSanitizer(), second); third.Hosts["c36b8048-93ce-4385-ac19-ab5c90574b77"] != "web" {
+		t.Errorf("mapping of a removed host was dropped: %v", third.Hosts)
+	}
+}
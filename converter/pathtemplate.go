@@ -0,0 +1,205 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// DefaultPathTemplate builds the metric path from the display names
+const DefaultPathTemplate = "{{.HostName}}.{{.ServiceName}}.{{.Label}}"
+
+// Dot handling of the Sanitizer
+const (
+	// DotsReplace replaces dots like the other illegal characters
+	DotsReplace = "replace"
+	// DotsRemove removes the dots
+	DotsRemove = "remove"
+	// DotsKeep keeps the dots, so they start a new level in the metric tree
+	DotsKeep = "keep"
+)
+
+// Sanitizer turns names into components of the metric path
+type Sanitizer struct {
+	// Allowed is the content of the regexp character class of the allowed characters
+	Allowed     string
+	Replacement string
+	Lowercase   bool
+	// MaxLength limits the number of characters of a component, 0 is unlimited
+	MaxLength int
+	Dots      string
+
+	illegal *regexp.Regexp
+}
+
+// NewSanitizer checks the settings and compiles the character class
+func NewSanitizer(allowed, replacement string, lowercase bool, maxLength int, dots string) (*Sanitizer, error) {
+	sanitizer := &Sanitizer{
+		Allowed:     allowed,
+		Replacement: replacement,
+		Lowercase:   lowercase,
+		MaxLength:   maxLength,
+		Dots:        dots,
+	}
+	switch dots {
+	case DotsReplace, DotsRemove:
+		allowed = strings.Replace(strings.Replace(allowed, `\.`, "", -1), ".", "", -1)
+	case DotsKeep:
+		allowed = `\.` + allowed
+	default:
+		return nil, fmt.Errorf("invalid dot handling \"%s\"", dots)
+	}
+	if allowed == "" {
+		return nil, fmt.Errorf("no allowed characters")
+	}
+	var err error
+	if sanitizer.illegal, err = regexp.Compile("[^" + allowed + "]"); err != nil {
+		return nil, fmt.Errorf("invalid allowed characters: %s", err)
+	}
+	if sanitizer.illegal.MatchString(replacement) || strings.Contains(replacement, ".") {
+		return nil, fmt.Errorf("replacement \"%s\" is not allowed in metric names", replacement)
+	}
+	if maxLength < 0 {
+		return nil, fmt.Errorf("invalid max length %d", maxLength)
+	}
+	return sanitizer, nil
+}
+
+// DefaultSanitizer replaces everything except letters, digits, _ and - with _
+func DefaultSanitizer() *Sanitizer {
+	sanitizer, _ := NewSanitizer("a-zA-Z0-9_-", "_", false, 0, DotsReplace)
+	return sanitizer
+}
+
+// Component sanitizes s
+// With DotsKeep each part between the dots is limited to MaxLength and empty parts are removed.
+func (sanitizer *Sanitizer) Component(s string) string {
+	if sanitizer.Dots == DotsRemove {
+		s = strings.Replace(s, ".", "", -1)
+	}
+	if sanitizer.Lowercase {
+		s = strings.ToLower(s)
+	}
+	s = sanitizer.illegal.ReplaceAllString(s, sanitizer.Replacement)
+	if sanitizer.Dots != DotsKeep {
+		return sanitizer.truncate(s, sanitizer.MaxLength)
+	}
+	parts := make([]string, 0)
+	for _, part := range strings.Split(s, ".") {
+		if part != "" {
+			parts = append(parts, sanitizer.truncate(part, sanitizer.MaxLength))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// withSuffix appends suffix to the component and keeps it within MaxLength
+func (sanitizer *Sanitizer) withSuffix(component, suffix string) string {
+	suffix = "_" + sanitizer.Component(suffix)
+	if sanitizer.MaxLength > len(suffix) {
+		component = sanitizer.truncate(component, sanitizer.MaxLength-len(suffix))
+	}
+	return component + suffix
+}
+
+func (sanitizer *Sanitizer) truncate(s string, length int) string {
+	if length > 0 {
+		if runes := []rune(s); len(runes) > length {
+			return string(runes[:length])
+		}
+	}
+	return s
+}
+
+// PathData is passed to the path template
+// Every field except Index is sanitized.
+type PathData struct {
+	// Host and Service are the uuids of the perfdata directory
+	Host    string
+	Service string
+	// HostName and ServiceName are the display names, or the uuids without -display-names
+	HostName    string
+	ServiceName string
+	// HostDisplayName and ServiceDisplayName are the display names from the xml file, they may not be unique
+	HostDisplayName    string
+	ServiceDisplayName string
+	Label              string
+	// Index is the number of the datasource in the rrd file starting with 1
+	Index int
+	// Unit is the unit of measurement of the datasource
+	Unit string
+	// Template is the PNP4Nagios template of the datasource, the check command uuid in openITCOCKPIT
+	Template string
+}
+
+// PathTemplate builds the metric names from a text/template
+// The dots of the result separate the levels of the metric tree.
+type PathTemplate struct {
+	template  *template.Template
+	sanitizer *Sanitizer
+	naming    *Naming
+}
+
+// NewPathTemplate parses the template, naming may be nil
+func NewPathTemplate(text string, sanitizer *Sanitizer, naming *Naming) (*PathTemplate, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %s", err)
+	}
+	pt := &PathTemplate{template: tmpl, sanitizer: sanitizer, naming: naming}
+	if _, err := pt.execute(&PathData{Host: "host", Service: "service", HostName: "host", ServiceName: "service", HostDisplayName: "host", ServiceDisplayName: "service", Label: "label", Index: 1, Unit: "unit", Template: "template"}); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+func (pt *PathTemplate) execute(data *PathData) (string, error) {
+	var sb strings.Builder
+	if err := pt.template.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("invalid path template: %s", err)
+	}
+	metric := sb.String()
+	for _, component := range strings.Split(metric, ".") {
+		if component == "" || strings.Contains(component, "/") {
+			return "", fmt.Errorf("path template creates invalid metric \"%s\"", metric)
+		}
+	}
+	return metric, nil
+}
+
+// Metric returns the metric name of the datasource at index
+// label is the datasource name without the suffix of the consolidation. Unknown uuids are used as they are.
+func (pt *PathTemplate) Metric(rrdSet *rrdpath.RrdSet, index int, label string) (string, error) {
+	data := &PathData{
+		Host:               pt.sanitizer.Component(rrdSet.Hostname),
+		Service:            pt.sanitizer.Component(rrdSet.Servicename),
+		HostDisplayName:    pt.sanitizer.Component(rrdSet.HostDisplayName),
+		ServiceDisplayName: pt.sanitizer.Component(rrdSet.ServiceDisplayName),
+		Label:              pt.sanitizer.Component(label),
+		Index:              index + 1,
+		Unit:               pt.sanitizer.Component(rrdSet.Unit(index)),
+	}
+	if index < len(rrdSet.Metadata) {
+		data.Template = pt.sanitizer.Component(rrdSet.Metadata[index].Template)
+	}
+	if pt.naming != nil {
+		data.HostName = pt.naming.Hosts[rrdSet.Hostname]
+		data.ServiceName = pt.naming.Services[rrdSet.Servicename]
+	}
+	if data.HostName == "" {
+		data.HostName = data.Host
+	}
+	if data.ServiceName == "" {
+		data.ServiceName = data.Service
+	}
+	if data.HostDisplayName == "" {
+		data.HostDisplayName = data.Host
+	}
+	if data.ServiceDisplayName == "" {
+		data.ServiceDisplayName = data.Service
+	}
+	return pt.execute(data)
+}
@@ -0,0 +1,142 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+func TestSanitizer(t *testing.T) {
+	tests := []struct {
+		allowed     string
+		replacement string
+		lowercase   bool
+		maxLength   int
+		dots        string
+		input       string
+		expected    string
+	}{
+		{"a-zA-Z0-9_-", "_", false, 0, DotsReplace, `C:\ used^%`, "C___used__"},
+		{"a-zA-Z0-9_-", "_", false, 0, DotsReplace, "web-01.example.com", "web-01_example_com"},
+		{"a-zA-Z0-9_-", "_", false, 0, DotsRemove, "web-01.example.com", "web-01examplecom"},
+		{"a-zA-Z0-9_-", "_", false, 4, DotsKeep, "web-01..example.com", "web-.exam.com"},
+		{"a-z0-9", "", true, 0, DotsReplace, "Disk /Var", "diskvar"},
+		{"a-z-", "-", false, 3, DotsReplace, "äbcd", "-bc"},
+	}
+	for _, test := range tests {
+		sanitizer, err := NewSanitizer(test.allowed, test.replacement, test.lowercase, test.maxLength, test.dots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := sanitizer.Component(test.input); result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, result)
+		}
+	}
+
+	invalid := [][]string{
+		{"a-z", "_", "keep"},
+		{"a-z", "_", "split"},
+		{"a-z_", ".", "replace"},
+		{"[", "_", "replace"},
+		{"", "_", "replace"},
+	}
+	for _, args := range invalid {
+		if _, err := NewSanitizer(args[0], args[1], false, 0, args[2]); err == nil {
+			t.Errorf("%v must fail", args)
+		}
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	naming := &Naming{
+		Hosts:    map[string]string{"c36b8048-93ce-4385-ac19-ab5c90574b77": "web_example_com"},
+		Services: map[string]string{"74fd8f59-1348-4e16-85f0-4a5c57c7dd62": "Ping"},
+	}
+	rrdSet := &rrdpath.RrdSet{
+		Hostname:    "c36b8048-93ce-4385-ac19-ab5c90574b77",
+		Servicename: "74fd8f59-1348-4e16-85f0-4a5c57c7dd62",
+		Datasources: []string{"rta", "pl"},
+		Metadata:    []*rrdpath.Datasource{{Unit: "ms", Template: "check_ping"}, {Unit: "%", Template: "check_ping"}},
+	}
+	pt, err := NewPathTemplate(DefaultPathTemplate, DefaultSanitizer(), naming)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvt := &Converter{Destination: "/dest", ArchivePath: "/archive", PathTemplate: pt}
+	paths, err := cvt.metricPaths(rrdSet, 0, "rta", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths.Metric != "web_example_com.Ping.rta" || paths.Destination != "/dest/web_example_com/Ping/rta.wsp" || paths.Archive != "/archive/web_example_com/Ping/rta.wsp" {
+		t.Errorf("unexpected paths %+v", paths)
+	}
+	// every run archives to its own directory
+	cvt.ArchiveRun = "20200101-120000"
+	if paths, err := cvt.metricPaths(rrdSet, 0, "rta", ""); err != nil || paths.Archive != "/archive/20200101-120000/web_example_com/Ping/rta.wsp" {
+		t.Errorf("unexpected paths %+v: %s", paths, err)
+	}
+	cvt.PathTemplate = nil
+	if paths, err := cvt.metricPaths(rrdSet, 0, "rta", ""); err != nil || paths.Archive != "/archive/20200101-120000/c36b8048-93ce-4385-ac19-ab5c90574b77/74fd8f59-1348-4e16-85f0-4a5c57c7dd62/rta.wsp" {
+		t.Errorf("unexpected paths %+v: %s", paths, err)
+	}
+
+	pt, err = NewPathTemplate("linux.{{.HostName}}.{{.Service}}.{{.Index}}_{{.Label}}_{{.Unit}}", DefaultSanitizer(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without naming the uuids are used
+	if metric, err := pt.Metric(rrdSet, 1, "pl"); err != nil || metric != "linux.c36b8048-93ce-4385-ac19-ab5c90574b77.74fd8f59-1348-4e16-85f0-4a5c57c7dd62.2_pl__" {
+		t.Errorf("unexpected metric %s: %s", metric, err)
+	}
+
+	rrdSet.HostDisplayName = "web.example.com"
+	pt, err = NewPathTemplate("{{.HostDisplayName}}.{{.ServiceDisplayName}}.{{.Template}}.{{.Label}}", DefaultSanitizer(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric, err := pt.Metric(rrdSet, 0, "rta"); err != nil || metric != "web_example_com.74fd8f59-1348-4e16-85f0-4a5c57c7dd62.check_ping.rta" {
+		t.Errorf("unexpected metric %s: %s", metric, err)
+	}
+
+	for _, tmpl := range []string{"{{.HostName}}..{{.Label}}", "{{.Hostname}}.{{.Label}}", "{{.HostName}}/{{.Label}}", "{{.HostName"} {
+		if _, err := NewPathTemplate(tmpl, DefaultSanitizer(), nil); err == nil {
+			t.Errorf("template %s must fail", tmpl)
+		}
+	}
+}
+
+func TestReplaceIllegalCharacters(t *testing.T) {
+	if result := replaceIllegalCharacters(`rta-ms.min C:\ ^x`); result != "rta-ms.min_C____x" {
+		t.Errorf("unexpected result %s", result)
+	}
+}
+
+func TestPathTemplateMinMax(t *testing.T) {
+	rrdSet := &rrdpath.RrdSet{
+		Hostname:        "host1",
+		Servicename:     "service1",
+		HostDisplayName: "web.example.com",
+		Datasources:     []string{"rta"},
+	}
+	keep, err := NewSanitizer("a-zA-Z0-9_-", "_", false, 0, DotsKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the suffix of the consolidation is not sanitized, so the files are <label>.min.wsp like without template
+	for _, sanitizer := range []*Sanitizer{DefaultSanitizer(), keep} {
+		pt, err := NewPathTemplate("{{.HostName}}.{{.Label}}", sanitizer, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cvt := &Converter{Destination: "/dest", MinMax: true, PathTemplate: pt}
+		for _, c := range []*consolidation{averageConsolidation, minConsolidation, maxConsolidation} {
+			paths, err := cvt.metricPaths(rrdSet, 0, "rta", c.Suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if paths.Metric != "host1.rta"+c.Suffix || paths.Destination != "/dest/host1/rta"+c.Suffix+".wsp" {
+				t.Errorf("%s %s: unexpected paths %+v", sanitizer.Dots, c.Cf, paths)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+// pickle protocol 2 opcodes used by carbon's pickle receiver
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinPut     = 'q'
+	pickleBinGet     = 'h'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// picklePoints encodes the points as carbon pickle message including the length header
+// The message is a list of (path, (timestamp, value)) tuples.
+func picklePoints(name string, points []*whisper.TimeSeriesPoint) []byte {
+	var buf bytes.Buffer
+	// reserve space for the length header
+	buf.Write([]byte{0, 0, 0, 0})
+	buf.Write([]byte{pickleProto, 2, pickleEmptyList, pickleMark})
+
+	for i, pt := range points {
+		if i == 0 {
+			buf.WriteByte(pickleBinUnicode)
+			binary.Write(&buf, binary.LittleEndian, uint32(len(name)))
+			buf.WriteString(name)
+			// memorize the path, so it is written only once
+			buf.Write([]byte{pickleBinPut, 0})
+		} else {
+			buf.Write([]byte{pickleBinGet, 0})
+		}
+
+		if pt.Time >= math.MinInt32 && pt.Time <= math.MaxInt32 {
+			buf.WriteByte(pickleBinInt)
+			binary.Write(&buf, binary.LittleEndian, int32(pt.Time))
+		} else {
+			buf.Write([]byte{pickleLong1, 8})
+			binary.Write(&buf, binary.LittleEndian, int64(pt.Time))
+		}
+		buf.WriteByte(pickleBinFloat)
+		binary.Write(&buf, binary.BigEndian, pt.Value)
+		buf.Write([]byte{pickleTuple2, pickleTuple2})
+	}
+	buf.Write([]byte{pickleAppends, pickleStop})
+
+	msg := buf.Bytes()
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+	return msg
+}
+
+// PickleSink sends the data with the carbon pickle protocol
+type PickleSink struct {
+	prefix    string
+	batchSize int
+	writer    *carbonWriter
+}
+
+// NewPickleSink connects to the carbon pickle receiver (usually port 2004)
+// Every metric name is prefixed with prefix if it is not empty.
+// The points of a metric are sent in messages of batchSize points over concurrency connections.
+// Send blocks if too many messages are pending, Flush waits until the messages of a service are sent.
+func NewPickleSink(ctx context.Context, address, prefix string, batchSize, concurrency int) (*PickleSink, error) {
+	writer, err := newCarbonWriter(ctx, "tcp", address, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = sinkBatchSize
+	}
+	return &PickleSink{
+		prefix:    prefix,
+		batchSize: batchSize,
+		writer:    writer,
+	}, nil
+}
+
+// Send queues the points as pickle messages
+func (ps *PickleSink) Send(ctx context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	name := carbonMetricName(ps.prefix, metric)
+	key := sinkKey(metric.Hostname, metric.Servicename)
+	for len(points) > 0 {
+		end := len(points)
+		if end > ps.batchSize {
+			end = ps.batchSize
+		}
+		if err := ps.writer.push(ctx, key, picklePoints(name, points[:end])); err != nil {
+			return err
+		}
+		points = points[end:]
+	}
+	return nil
+}
+
+// Flush waits until the queued messages of the service are sent
+func (ps *PickleSink) Flush(ctx context.Context, hostname, servicename string) error {
+	return ps.writer.flush(ctx, sinkKey(hostname, servicename))
+}
+
+// Close waits until all queued data is sent
+// Send must not be called after Close.
+func (ps *PickleSink) Close() error {
+	return ps.writer.close()
+}
@@ -0,0 +1,107 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+func TestPicklePoints(t *testing.T) {
+	msg := picklePoints("a.b", []*whisper.TimeSeriesPoint{
+		{Time: 1600000000, Value: 1.5},
+		{Time: 1600000060, Value: 2},
+	})
+	expected := []byte{
+		0, 0, 0, 50,
+		pickleProto, 2, pickleEmptyList, pickleMark,
+		pickleBinUnicode, 3, 0, 0, 0, 'a', '.', 'b', pickleBinPut, 0,
+		pickleBinInt, 0x00, 0x10, 0x5e, 0x5f,
+		pickleBinFloat, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		pickleTuple2, pickleTuple2,
+		pickleBinGet, 0,
+		pickleBinInt, 0x3c, 0x10, 0x5e, 0x5f,
+		pickleBinFloat, 0x40, 0, 0, 0, 0, 0, 0, 0,
+		pickleTuple2, pickleTuple2,
+		pickleAppends, pickleStop,
+	}
+	if !bytes.Equal(msg, expected) {
+		t.Errorf("unexpected pickle message:\n%v\n%v", msg, expected)
+	}
+}
+
+func TestPickleSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		mutex    sync.Mutex
+		messages int
+		wg       sync.WaitGroup
+	)
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		// one connection per concurrency
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func(conn net.Conn) {
+				defer wg.Done()
+				defer conn.Close()
+				header := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					if _, err := io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint32(header))); err != nil {
+						return
+					}
+					mutex.Lock()
+					messages++
+					mutex.Unlock()
+				}
+			}(conn)
+		}
+	}()
+
+	sink, err := NewPickleSink(context.Background(), ln.Addr().String(), "", 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric := &SinkMetric{Name: "host1.service1.label1", Hostname: "host1", Servicename: "service1"}
+	if err := sink.Send(context.Background(), metric, testSinkPoints(25)); err != nil {
+		t.Fatal(err)
+	}
+	// the messages are spread over both connections, Flush waits for all of them
+	if err := sink.Flush(context.Background(), "host1", "service1"); err != nil {
+		t.Fatal(err)
+	}
+	sink.writer.mutex.Lock()
+	pending := len(sink.writer.pending)
+	sink.writer.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("%d services with pending messages after Flush", pending)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	wg.Wait()
+
+	if messages != 3 {
+		t.Errorf("expected 3 pickle messages, got %d", messages)
+	}
+}
@@ -0,0 +1,136 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// Actions of a planned file
+const (
+	PlanCreate    = "create"
+	PlanMerge     = "merge"
+	PlanArchive   = "archive"
+	PlanOverwrite = "overwrite"
+	PlanSend      = "send"
+)
+
+// PlanFile is a whisper file or sink metric the conversion would write
+type PlanFile struct {
+	// Path is the whisper file or the metric name if a sink is used
+	Path        string
+	Retention   string
+	Actions     []string
+	ArchivePath string
+}
+
+// Plan lists the operations a conversion of the rrd set would do
+type Plan struct {
+	RrdSet *rrdpath.RrdSet
+	Files  []*PlanFile
+	// Renamed maps the original names of the datasources that are renamed to avoid collisions
+	Renamed map[string]string
+	// Err is the error the conversion would fail with
+	Err error
+}
+
+// String formats the plan with one line per file
+func (plan *Plan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%s/%s)\n", plan.RrdSet.RrdPath, plan.RrdSet.Hostname, plan.RrdSet.Servicename)
+	labels := make([]string, 0, len(plan.Renamed))
+	for label := range plan.Renamed {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(&sb, "  %-24s \"%s\" -> \"%s\"\n", "rename", label, plan.Renamed[label])
+	}
+	for _, fl := range plan.Files {
+		fmt.Fprintf(&sb, "  %-24s %s", strings.Join(fl.Actions, ","), fl.Path)
+		if fl.Retention != "" {
+			fmt.Fprintf(&sb, " retention=%s", fl.Retention)
+		}
+		if fl.ArchivePath != "" {
+			fmt.Fprintf(&sb, " archive=%s", fl.ArchivePath)
+		}
+		sb.WriteByte('\n')
+	}
+	if plan.Err != nil {
+		fmt.Fprintf(&sb, "  fail: %s\n", plan.Err)
+	}
+	return sb.String()
+}
+
+// Plan resolves the labels and builds the paths like Convert, but only reports the operations
+// The rrd file and existing whisper files are read, nothing is written.
+func (cvt *Converter) Plan(rrdSet *rrdpath.RrdSet) *Plan {
+	plan := &Plan{RrdSet: rrdSet}
+	plan.Err = cvt.plan(rrdSet, plan)
+	return plan
+}
+
+func (cvt *Converter) plan(rrdSet *rrdpath.RrdSet, plan *Plan) error {
+	renamed, err := cvt.resolveLabels(rrdSet)
+	if err != nil {
+		return err
+	}
+	plan.Renamed = renamed
+
+	if cvt.Sink != nil {
+		for i, label := range rrdSet.Datasources {
+			metric, err := cvt.metric(rrdSet, i, label, "")
+			if err != nil {
+				return err
+			}
+			plan.Files = append(plan.Files, &PlanFile{
+				Path:    metric,
+				Actions: []string{PlanSend},
+			})
+		}
+		return nil
+	}
+
+	info, err := readRrdSetInfo(rrdSet)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cvt.consolidations(rrdSet, info) {
+		if len(info.archives(c.Cf)) == 0 {
+			return fmt.Errorf("rrd file has no %s rra", c.Cf)
+		}
+		retention, err := consolidationRetention(info, c)
+		if err != nil {
+			return err
+		}
+		for i, label := range rrdSet.Datasources {
+			paths, err := cvt.metricPaths(rrdSet, i, label, c.Suffix)
+			if err != nil {
+				return err
+			}
+			fl := &PlanFile{
+				Path:      paths.Destination,
+				Retention: retentionString(cvt.SchemaRules.Retentions(paths.Metric, retention)),
+			}
+			if _, err := os.Stat(fl.Path); os.IsNotExist(err) {
+				fl.Actions = []string{PlanCreate}
+			} else {
+				if cvt.Merge {
+					fl.Actions = append(fl.Actions, PlanMerge)
+				}
+				if paths.Archive != "" {
+					fl.Actions = append(fl.Actions, PlanArchive)
+					fl.ArchivePath = paths.Archive
+				} else {
+					fl.Actions = append(fl.Actions, PlanOverwrite)
+				}
+			}
+			plan.Files = append(plan.Files, fl)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package converter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/oitcdb"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+	"github.com/it-novum/rrd2whisper/testsuite"
+	perfdata "github.com/jabdr/nagios-perfdata"
+)
+
+func TestPlanLabelMismatch(t *testing.T) {
+	cvt := &Converter{UUIDToPerfdata: oitcdb.UUIDToPerfdata{"service1": "a=1 b=2 c=3"}}
+	rrdSet := &rrdpath.RrdSet{RrdPath: "/perfdata/host1/service1.rrd", Hostname: "host1", Servicename: "service1", Datasources: []string{"label1"}}
+	plan := cvt.Plan(rrdSet)
+	if plan.Err == nil || len(plan.Files) != 0 {
+		t.Fatalf("expected datasource count mismatch, got %+v", plan)
+	}
+	if !strings.Contains(plan.String(), "fail: invalid number of perfdata values") {
+		t.Errorf("unexpected plan output:\n%s", plan)
+	}
+}
+
+func TestLabelCollision(t *testing.T) {
+	cvt := &Converter{Destination: "/dest", MinMax: true}
+	rrdSet := &rrdpath.RrdSet{RrdPath: "/perfdata/host1/service1.rrd", Hostname: "host1", Servicename: "service1", Datasources: []string{"disk /", "disk_/", "disk___2", "a", "a.min"}}
+	renamed, err := cvt.resolveLabels(rrdSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"disk /", "disk_/_2", "disk___2_3", "a", "a.min_5"}
+	if !reflect.DeepEqual(rrdSet.Datasources, expected) {
+		t.Errorf("expected labels %v, got %v", expected, rrdSet.Datasources)
+	}
+	if len(renamed) != 3 || renamed["disk_/"] != "disk_/_2" || renamed["a.min"] != "a.min_5" {
+		t.Errorf("unexpected renamed labels %v", renamed)
+	}
+
+	// the labels must stay the same if they are resolved again
+	if renamed, err = cvt.resolveLabels(rrdSet); err != nil || len(renamed) != 0 {
+		t.Errorf("resolved labels changed: %v %s", renamed, err)
+	}
+
+	// a template without the label writes every datasource to the same file
+	if cvt.PathTemplate, err = NewPathTemplate("{{.HostName}}.{{.ServiceName}}", DefaultSanitizer(), nil); err != nil {
+		t.Fatal(err)
+	}
+	rrdSet.Datasources = []string{"a", "b"}
+	if _, err := cvt.resolveLabels(rrdSet); err == nil {
+		t.Error("collision must fail")
+	}
+
+	cvt = &Converter{Destination: "/dest"}
+	rrdSet.Datasources = []string{"disk /", "disk_/"}
+	plan := &Plan{RrdSet: rrdSet}
+	// the rrd file does not exist, the labels are resolved before
+	if err := cvt.plan(rrdSet, plan); err == nil {
+		t.Error("missing rrd file must fail")
+	}
+	if !strings.Contains(plan.String(), `rename                   "disk_/" -> "disk_/_2"`) {
+		t.Errorf("unexpected plan output:\n%s", plan)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ts := testsuite.Prepare()
+	defer ts.Shutdown()
+
+	SetRetention("60s:365d")
+
+	pf, err := perfdata.ParsePerfdata("label1=0%;0;0;0; 'labe l2'=34")
+	if err != nil {
+		panic(err)
+	}
+	testsuite.CreateRrd(ts.Source, "host1", "service1", pf, time.Now().Add(-testsuite.DAY), time.Now(), false)
+	workdata, err := rrdpath.NewWorkdata(rrdpath.Walk(context.Background(), ts.Source), nil, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing whisper file is merged and archived
+	os.MkdirAll(ts.Destination+"/host1/service1", 0755)
+	if err := ioutil.WriteFile(ts.Destination+"/host1/service1/label1.wsp", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cvt := &Converter{Destination: ts.Destination, ArchivePath: ts.Archive, TempPath: ts.Temp, Merge: true, UUIDToPerfdata: make(oitcdb.UUIDToPerfdata)}
+	plan := cvt.Plan(workdata.RrdSets[0])
+	if plan.Err != nil {
+		t.Fatal(plan.Err)
+	}
+	if len(plan.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(plan.Files))
+	}
+	if actions := strings.Join(plan.Files[0].Actions, ","); actions != "merge,archive" || plan.Files[0].ArchivePath != ts.Archive+"/host1/service1/label1.wsp" {
+		t.Errorf("unexpected plan for existing file %+v", plan.Files[0])
+	}
+	if actions := strings.Join(plan.Files[1].Actions, ","); actions != "create" || plan.Files[1].Retention != "60:525600" {
+		t.Errorf("unexpected plan for new file %+v", plan.Files[1])
+	}
+	if _, err := os.Stat(ts.Destination + "/host1/service1/labe_l2.wsp"); !os.IsNotExist(err) {
+		t.Error("dry run must not create whisper files")
+	}
+}
@@ -0,0 +1,234 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/golang/snappy"
+)
+
+var prometheusNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+var prometheusLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func checkPrometheusName(name string) error {
+	if !prometheusNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid prometheus metric name \"%s\"", name)
+	}
+	return nil
+}
+
+// prometheusLabels returns the labels of the time series sorted by name
+func prometheusLabels(name string, metric *SinkMetric) [][2]string {
+	return [][2]string{
+		{"__name__", name},
+		{"host", metric.Hostname},
+		{"metric", metric.Label},
+		{"service", metric.Servicename},
+	}
+}
+
+// protobuf wire format helpers for the remote write messages
+
+func protoVarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func protoBytes(buf *bytes.Buffer, field int, data []byte) {
+	protoVarint(buf, uint64(field<<3|2))
+	protoVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// remoteWriteRequest encodes a prometheus.WriteRequest with a single prometheus.TimeSeries
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func remoteWriteRequest(labels [][2]string, points []*whisper.TimeSeriesPoint) []byte {
+	var series, msg bytes.Buffer
+	for _, label := range labels {
+		msg.Reset()
+		protoBytes(&msg, 1, []byte(label[0]))
+		protoBytes(&msg, 2, []byte(label[1]))
+		protoBytes(&series, 1, msg.Bytes())
+	}
+	for _, pt := range points {
+		msg.Reset()
+		// double value = 1, fixed64
+		protoVarint(&msg, 1<<3|1)
+		binary.Write(&msg, binary.LittleEndian, math.Float64bits(pt.Value))
+		// int64 timestamp = 2 in milliseconds, varint
+		protoVarint(&msg, 2<<3|0)
+		protoVarint(&msg, uint64(int64(pt.Time)*1000))
+		protoBytes(&series, 2, msg.Bytes())
+	}
+
+	var req bytes.Buffer
+	protoBytes(&req, 1, series.Bytes())
+	return req.Bytes()
+}
+
+// RemoteWriteSink sends the data with the prometheus remote write protocol
+// Every datasource is a time series named name with the labels host, service and metric.
+type RemoteWriteSink struct {
+	name      string
+	batchSize int
+	writer    *httpWriter
+}
+
+// NewRemoteWriteSink creates a sink for the remote write endpoint at rawURL
+// (e.g. http://localhost:8428/api/v1/write for VictoriaMetrics)
+// Credentials can be passed as user info of the url.
+func NewRemoteWriteSink(rawURL, name string, batchSize int) (*RemoteWriteSink, error) {
+	if err := checkPrometheusName(name); err != nil {
+		return nil, err
+	}
+	u, err := parseHTTPURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote write url: %s", err)
+	}
+	if batchSize <= 0 {
+		batchSize = sinkBatchSize
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "application/x-protobuf")
+	header.Set("Content-Encoding", "snappy")
+	header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return &RemoteWriteSink{
+		name:      name,
+		batchSize: batchSize,
+		writer:    newHTTPWriter("remote write", u, header),
+	}, nil
+}
+
+// Send posts the points in batches of batchSize samples
+func (rws *RemoteWriteSink) Send(ctx context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	labels := prometheusLabels(rws.name, metric)
+	for len(points) > 0 {
+		end := len(points)
+		if end > rws.batchSize {
+			end = rws.batchSize
+		}
+		body := snappy.Encode(nil, remoteWriteRequest(labels, points[:end]))
+		if err := rws.writer.write(ctx, body); err != nil {
+			return err
+		}
+		points = points[end:]
+	}
+	return nil
+}
+
+// Flush does nothing, every Send is written immediately
+func (rws *RemoteWriteSink) Flush(context.Context, string, string) error {
+	return nil
+}
+
+// Close does nothing, every Send is written immediately
+func (rws *RemoteWriteSink) Close() error {
+	return nil
+}
+
+// OpenMetricsSink writes the data as OpenMetrics text file
+// The file can be imported with promtool tsdb create-blocks-from openmetrics.
+type OpenMetricsSink struct {
+	name   string
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewOpenMetricsSink creates or truncates the file at path
+func NewOpenMetricsSink(path, name string) (*OpenMetricsSink, error) {
+	if err := checkPrometheusName(name); err != nil {
+		return nil, err
+	}
+	fl, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not create openmetrics output file: %s", err)
+	}
+	oms := &OpenMetricsSink{
+		name:   name,
+		file:   fl,
+		writer: bufio.NewWriter(fl),
+	}
+	fmt.Fprintf(oms.writer, "# TYPE %s gauge\n", name)
+	return oms, nil
+}
+
+// Send writes the points to the file
+func (oms *OpenMetricsSink) Send(_ context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error {
+	var series strings.Builder
+	series.WriteString(oms.name)
+	series.WriteByte('{')
+	for i, label := range prometheusLabels(oms.name, metric)[1:] {
+		if i > 0 {
+			series.WriteByte(',')
+		}
+		series.WriteString(label[0])
+		series.WriteString("=\"")
+		series.WriteString(prometheusLabelEscaper.Replace(label[1]))
+		series.WriteByte('"')
+	}
+	series.WriteString("} ")
+	prefix := series.String()
+
+	var buf bytes.Buffer
+	for _, pt := range points {
+		buf.WriteString(prefix)
+		buf.WriteString(strconv.FormatFloat(pt.Value, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(pt.Time))
+		buf.WriteByte('\n')
+	}
+
+	oms.mutex.Lock()
+	defer oms.mutex.Unlock()
+	if _, err := oms.writer.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write openmetrics output file: %s", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered data to the file and syncs it to disk
+func (oms *OpenMetricsSink) Flush(_ context.Context, _, _ string) error {
+	oms.mutex.Lock()
+	defer oms.mutex.Unlock()
+	return oms.sync()
+}
+
+func (oms *OpenMetricsSink) sync() error {
+	if err := oms.writer.Flush(); err != nil {
+		return fmt.Errorf("could not write openmetrics output file: %s", err)
+	}
+	if err := oms.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync openmetrics output file: %s", err)
+	}
+	return nil
+}
+
+// Close writes the end marker, flushes and closes the file
+func (oms *OpenMetricsSink) Close() error {
+	oms.mutex.Lock()
+	defer oms.mutex.Unlock()
+	oms.writer.WriteString("# EOF\n")
+	if err := oms.sync(); err != nil {
+		oms.file.Close()
+		return err
+	}
+	return oms.file.Close()
+}
@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+type protoField struct {
+	number int
+	value  uint64
+	data   []byte
+}
+
+func decodeProtoFields(t *testing.T, b []byte) []protoField {
+	fields := make([]protoField, 0)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			field.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			field.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := decodeProtoFields(t, body)
+		if len(request) != 1 || request[0].number != 1 {
+			t.Fatalf("expected one time series")
+		}
+		labels := make(map[string]string)
+		samples := 0
+		for _, field := range decodeProtoFields(t, request[0].data) {
+			sub := decodeProtoFields(t, field.data)
+			switch field.number {
+			case 1:
+				labels[string(sub[0].data)] = string(sub[1].data)
+			case 2:
+				if requests == 1 && samples == 1 && (math.Float64frombits(sub[0].value) != 0.5 || sub[1].value != 1600000060000) {
+					t.Errorf("unexpected sample %v", sub)
+				}
+				samples++
+			}
+		}
+		if labels["__name__"] != "openitcockpit_perfdata" || labels["host"] != "host1" || labels["service"] != "service 1" || labels["metric"] != "disk_" {
+			t.Errorf("unexpected labels %v", labels)
+		}
+		if samples != 5 && samples != 2 {
+			t.Errorf("unexpected number of samples %d", samples)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL+"/api/v1/write", "openitcockpit_perfdata", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testInfluxMetric, testSinkPoints(7)); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestOpenMetricsSink(t *testing.T) {
+	fl, err := ioutil.TempFile("", "rrd2whisper-openmetrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	defer os.Remove(fl.Name())
+
+	if _, err := NewOpenMetricsSink(fl.Name(), "invalid-name"); err == nil {
+		t.Error("expected error for invalid metric name")
+	}
+
+	sink, err := NewOpenMetricsSink(fl.Name(), "openitcockpit_perfdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testInfluxMetric, testSinkPoints(2)); err != nil {
+		t.Fatal(err)
+	}
+	// the data is in the file before the sink is closed
+	if err := sink.Flush(context.Background(), testInfluxMetric.Hostname, testInfluxMetric.Servicename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fl.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected 3 lines after Flush, got %d", lines)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = ioutil.ReadFile(fl.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE openitcockpit_perfdata gauge
+openitcockpit_perfdata{host="host1",metric="disk_",service="service 1"} 0 1600000000
+openitcockpit_perfdata{host="host1",metric="disk_",service="service 1"} 0.5 1600000060
+# EOF
+`
+	if string(data) != expected {
+		t.Errorf("unexpected file content:\n%s", strings.TrimSpace(string(data)))
+	}
+}
@@ -0,0 +1,251 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+const progressFilename = "progress.json"
+
+// progressFile records the settings and the number of written points of a temporary whisper file
+type progressFile struct {
+	Retention    string                    `json:"retention"`
+	Aggregation  whisper.AggregationMethod `json:"aggregation"`
+	XFilesFactor float32                   `json:"xff"`
+	Points       int                       `json:"points"`
+}
+
+// convertProgress is stored in the temporary directory of an interrupted conversion
+// Consolidations before Consolidation are complete, the rra's of the current consolidation are
+// written from coarse to fine and Archive is the index of the rra that was interrupted after
+// the row at Time.
+// The rrd file may be updated until the conversion is resumed, so the progress is only bound
+// to its archive layout and the rows after LastUpdates are added when resuming.
+type convertProgress struct {
+	RrdPath        string                   `json:"rrd"`
+	Step           int                      `json:"step"`
+	Archives       []*rrdArchive            `json:"archives"`
+	Labels         []string                 `json:"labels"`
+	Consolidations []string                 `json:"consolidations"`
+	Consolidation  int                      `json:"consolidation"`
+	Archive        int                      `json:"archive"`
+	Time           int                      `json:"time"`
+	LastUpdates    []int                    `json:"last_updates"`
+	Files          map[string]*progressFile `json:"files"`
+
+	// resume is set if the temporary whisper files of an interrupted run exist
+	resume bool
+}
+
+// resumeDir returns the temporary directory of the rrd set, which is kept if the conversion is interrupted
+func (cvt *Converter) resumeDir(rrdSet *rrdpath.RrdSet) string {
+	return filepath.Join(cvt.TempPath, "rrd2whisper-resume", rrdSet.Hostname, rrdSet.Servicename)
+}
+
+// lockResumeDir locks the temporary directory of the rrd set, so concurrent runs can't share
+// the progress. The lock is released by the returned func or when the process exits.
+func lockResumeDir(tmpdir string) (func(), error) {
+	lockPath := tmpdir + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %s", err)
+	}
+	fl, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not create lock file: %s", err)
+	}
+	if err := syscall.Flock(int(fl.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fl.Close()
+		return nil, fmt.Errorf("%s is used by another process", tmpdir)
+	}
+	// the previous owner removes the lock file when it unlocks, so the locked file may be gone
+	var flStat, pathStat syscall.Stat_t
+	if syscall.Fstat(int(fl.Fd()), &flStat) != nil || syscall.Stat(lockPath, &pathStat) != nil || flStat.Ino != pathStat.Ino {
+		fl.Close()
+		return nil, fmt.Errorf("%s is used by another process", tmpdir)
+	}
+	return func() {
+		os.Remove(lockPath)
+		fl.Close()
+	}, nil
+}
+
+// CleanResume removes the temporary directories of interrupted conversions whose rrd set
+// no longer exists or was converted since, converted is called with the rrd path.
+// Directories of conversions running in other processes are kept.
+func (cvt *Converter) CleanResume(converted func(rrdPath string) bool) error {
+	dirs, err := filepath.Glob(filepath.Join(cvt.TempPath, "rrd2whisper-resume", "*", "*"))
+	if err != nil {
+		return fmt.Errorf("could not search resume directories: %s", err)
+	}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		unlock, err := lockResumeDir(dir)
+		if err != nil {
+			continue
+		}
+		saved := new(convertProgress)
+		stale := true
+		if data, err := ioutil.ReadFile(filepath.Join(dir, progressFilename)); err == nil && json.Unmarshal(data, saved) == nil {
+			// the xml file exists with every storage type
+			_, err := os.Stat(strings.TrimSuffix(saved.RrdPath, ".rrd") + ".xml")
+			stale = err != nil || converted(saved.RrdPath)
+		}
+		if stale {
+			logging.Log("Removing stale resume directory %s", dir)
+			err = os.RemoveAll(dir)
+		}
+		unlock()
+		if err != nil {
+			return fmt.Errorf("could not remove resume directory: %s", err)
+		}
+	}
+	return nil
+}
+
+// fileSettings returns the settings of every whisper file of the rrd set by the label with suffix
+func (cvt *Converter) fileSettings(rrdSet *rrdpath.RrdSet, info *rrdInfo, consolidations []*consolidation) (map[string]*progressFile, error) {
+	settings := make(map[string]*progressFile)
+	for _, c := range consolidations {
+		retention, err := consolidationRetention(info, c)
+		if err != nil {
+			return nil, err
+		}
+		for i, label := range rrdSet.Datasources {
+			paths, err := cvt.metricPaths(rrdSet, i, label, c.Suffix)
+			if err != nil {
+				return nil, err
+			}
+			aggregation, xFilesFactor := cvt.AggregationRules.Aggregation(paths.Metric, rrdSet.Unit(i), c.Aggregation, 0.5)
+			settings[replaceIllegalCharacters(label+c.Suffix)] = &progressFile{
+				Retention:    retentionString(cvt.SchemaRules.Retentions(paths.Metric, retention)),
+				Aggregation:  aggregation,
+				XFilesFactor: xFilesFactor,
+			}
+		}
+	}
+	return settings, nil
+}
+
+// changedFile returns the label of the first temporary whisper file whose settings differ, or ""
+func (progress *convertProgress) changedFile(settings map[string]*progressFile) string {
+	for label, pf := range progress.Files {
+		current := settings[label]
+		if current == nil || current.Retention != pf.Retention || current.Aggregation != pf.Aggregation || current.XFilesFactor != pf.XFilesFactor {
+			return label
+		}
+	}
+	return ""
+}
+
+// loadProgress reads the progress of an interrupted conversion from tmpdir
+// If there is no progress, it does not belong to the current rrd file or the settings of a
+// file have changed, tmpdir is cleared and a new progress is returned.
+func loadProgress(tmpdir string, rrdSet *rrdpath.RrdSet, info *rrdInfo, consolidations []*consolidation, settings map[string]*progressFile) (*convertProgress, error) {
+	cfs := make([]string, len(consolidations))
+	for i, c := range consolidations {
+		cfs[i] = c.Cf
+	}
+	progress := &convertProgress{
+		RrdPath:        rrdSet.RrdPath,
+		Step:           info.Step,
+		Archives:       info.Archives,
+		Labels:         rrdSet.Datasources,
+		Consolidations: cfs,
+		LastUpdates:    make([]int, len(consolidations)),
+		Files:          make(map[string]*progressFile),
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(tmpdir, progressFilename)); err == nil {
+		saved := new(convertProgress)
+		if err := json.Unmarshal(data, saved); err == nil &&
+			saved.RrdPath == progress.RrdPath &&
+			saved.Step == progress.Step &&
+			reflect.DeepEqual(saved.Archives, progress.Archives) &&
+			reflect.DeepEqual(saved.Labels, progress.Labels) &&
+			reflect.DeepEqual(saved.Consolidations, progress.Consolidations) &&
+			len(saved.LastUpdates) == len(consolidations) {
+			if label := saved.changedFile(settings); label != "" {
+				logging.Log("settings of %s have changed, converting %s from the start", label, rrdSet.RrdPath)
+			} else {
+				saved.resume = true
+				return saved, nil
+			}
+		}
+	}
+
+	if err := os.RemoveAll(tmpdir); err != nil {
+		return nil, fmt.Errorf("could not clear temporary directory: %s", err)
+	}
+	if err := os.MkdirAll(tmpdir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %s", err)
+	}
+	return progress, nil
+}
+
+// save writes the progress to tmpdir
+func (progress *convertProgress) save(tmpdir string) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("could not create progress file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, progressFilename), data, 0644); err != nil {
+		return fmt.Errorf("could not write progress file: %s", err)
+	}
+	return nil
+}
+
+// openConvertSource opens the temporary whisper file of an interrupted conversion
+func (progress *convertProgress) openConvertSource(label, tmpdir string, paths *metricPaths, retention whisper.Retentions, aggregation whisper.AggregationMethod, xFilesFactor float32) (*convertSource, error) {
+	newLabel := replaceIllegalCharacters(label)
+	retentionDef := retentionString(retention)
+	pf := progress.Files[newLabel]
+	if pf == nil || pf.Retention != retentionDef || pf.Aggregation != aggregation || pf.XFilesFactor != xFilesFactor {
+		return nil, fmt.Errorf("could not resume conversion of %s, the settings have changed", newLabel)
+	}
+
+	var err error
+	cs := convertSource{
+		Label:               newLabel,
+		TempFilename:        fmt.Sprintf("%s/%s.wsp", tmpdir, newLabel),
+		DestinationFilename: paths.Destination,
+		ArchiveFilename:     paths.Archive,
+		Retention:           retentionDef,
+		Points:              pf.Points,
+	}
+	cs.Whisper, err = whisper.Open(cs.TempFilename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open whisper file: %s", err)
+	}
+	return &cs, nil
+}
+
+// addSource records the settings of a newly created temporary whisper file
+func (progress *convertProgress) addSource(cs *convertSource, aggregation whisper.AggregationMethod, xFilesFactor float32) {
+	progress.Files[cs.Label] = &progressFile{
+		Retention:    cs.Retention,
+		Aggregation:  aggregation,
+		XFilesFactor: xFilesFactor,
+	}
+}
+
+// interrupt records the current state of the sources and closes them
+func (progress *convertProgress) interrupt(sources []*convertSource) {
+	for _, cs := range sources {
+		if pf := progress.Files[cs.Label]; pf != nil {
+			pf.Points = cs.Points
+		}
+		cs.Whisper.Close()
+	}
+}
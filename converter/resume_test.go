@@ -0,0 +1,176 @@
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+func TestConvertProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cvt := &Converter{TempPath: dir}
+	rrdSet := &rrdpath.RrdSet{RrdPath: "/perfdata/host1/service1.rrd", Hostname: "host1", Servicename: "service1", Datasources: []string{"label 1"}}
+	info := &rrdInfo{Step: 60, LastUpdate: 1600000000}
+	consolidations := []*consolidation{averageConsolidation}
+	tmpdir := cvt.resumeDir(rrdSet)
+	retention, _ := whisper.ParseRetentionDefs("1m:1d,5m:7d")
+	paths := &metricPaths{Destination: "/dest/label_1.wsp"}
+	settings := map[string]*progressFile{"label_1": {Retention: "60:1440,300:2016", Aggregation: whisper.Average, XFilesFactor: 0.5}}
+
+	progress, err := loadProgress(tmpdir, rrdSet, info, consolidations, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.resume {
+		t.Fatal("new progress must not be resumed")
+	}
+	cs, err := newConvertSource("label 1", tmpdir, paths, retention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.addSource(cs, whisper.Average, 0.5)
+	cs.Points = 10
+	progress.Archive = 1
+	progress.Time = 1599990000
+	progress.interrupt([]*convertSource{cs})
+	if err := progress.save(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err = loadProgress(tmpdir, rrdSet, info, consolidations, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.resume || progress.Archive != 1 || progress.Time != 1599990000 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if _, err := progress.openConvertSource("label 1", tmpdir, paths, retention, whisper.Max, 0.5); err == nil {
+		t.Error("expected error for changed aggregation")
+	}
+	cs, err = progress.openConvertSource("label 1", tmpdir, paths, retention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Points != 10 || cs.Retention != "60:1440,300:2016" {
+		t.Errorf("unexpected source %+v", cs)
+	}
+	cs.Whisper.Close()
+
+	// the rrd file was updated since the interruption, the new rows are added when resuming
+	info.LastUpdate += 60
+	progress, err = loadProgress(tmpdir, rrdSet, info, consolidations, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.resume {
+		t.Error("progress of an updated rrd file must be resumed")
+	}
+	if err := progress.save(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the aggregation rules changed, the conversion starts again instead of failing
+	changed := map[string]*progressFile{"label_1": {Retention: "60:1440,300:2016", Aggregation: whisper.Max, XFilesFactor: 0.5}}
+	progress, err = loadProgress(tmpdir, rrdSet, info, consolidations, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.resume || len(progress.Files) != 0 {
+		t.Errorf("progress with changed settings must not be resumed %+v", progress)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "label_1.wsp")); !os.IsNotExist(err) {
+		t.Error("temporary files must be removed")
+	}
+	progress.Files = map[string]*progressFile{"label_1": {Retention: "60:1440,300:2016", Aggregation: whisper.Average, XFilesFactor: 0.5}}
+	if err := progress.save(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archives of the rrd file changed
+	info.Archives = []*rrdArchive{{Cf: "AVERAGE", PdpPerRow: 1, Rows: 1440, Step: 60}}
+	progress, err = loadProgress(tmpdir, rrdSet, info, consolidations, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.resume {
+		t.Error("progress of an rrd file with other archives must not be resumed")
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "label_1.wsp")); !os.IsNotExist(err) {
+		t.Error("temporary files must be removed")
+	}
+}
+
+func TestResumeLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpdir := filepath.Join(dir, "host1", "service1")
+
+	unlock, err := lockResumeDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockResumeDir(tmpdir); err == nil {
+		t.Error("second lock must fail")
+	}
+	unlock()
+	unlock, err = lockResumeDir(tmpdir)
+	if err != nil {
+		t.Fatalf("lock after unlock failed: %s", err)
+	}
+	unlock()
+}
+
+func TestCleanResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cvt := &Converter{TempPath: filepath.Join(dir, "temp")}
+	info := &rrdInfo{Step: 60, LastUpdate: 1600000000}
+	tmpdirs := make(map[string]string)
+	for _, service := range []string{"todo", "done", "deleted", "locked"} {
+		rrdSet := &rrdpath.RrdSet{RrdPath: filepath.Join(dir, "perfdata", "host1", service+".rrd"), Hostname: "host1", Servicename: service, Datasources: []string{"label"}}
+		if service != "deleted" {
+			writeTestFile(t, filepath.Join(dir, "perfdata", "host1", service+".xml"), "<NAGIOS/>")
+		}
+		tmpdirs[service] = cvt.resumeDir(rrdSet)
+		progress, err := loadProgress(tmpdirs[service], rrdSet, info, []*consolidation{averageConsolidation}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := progress.save(tmpdirs[service]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlock, err := lockResumeDir(tmpdirs["locked"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	converted := func(rrdPath string) bool {
+		return filepath.Base(rrdPath) != "todo.rrd"
+	}
+	if err := cvt.CleanResume(converted); err != nil {
+		t.Fatal(err)
+	}
+	for service, keep := range map[string]bool{"todo": true, "done": false, "deleted": false, "locked": true} {
+		if _, err := os.Stat(tmpdirs[service]); os.IsNotExist(err) == keep {
+			t.Errorf("%s: resume directory kept %t, expected %t", service, !os.IsNotExist(err), keep)
+		}
+	}
+}
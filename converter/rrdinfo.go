@@ -0,0 +1,189 @@
+package converter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+	"github.com/jabdr/rrd"
+)
+
+// rrdArchive describes one round robin archive of an rrd file
+type rrdArchive struct {
+	Cf        string
+	PdpPerRow int
+	Rows      int
+	// Step is the number of seconds covered by one row
+	Step int
+}
+
+// rrdInfo holds the header information of an rrd file
+type rrdInfo struct {
+	Step       int
+	LastUpdate int
+	Archives   []*rrdArchive
+}
+
+func infoInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case uint:
+		return int(v), nil
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("unexpected type %T", value)
+}
+
+func infoList(info map[string]interface{}, key string) ([]interface{}, error) {
+	list, ok := info[key].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rrd info has no %s", key)
+	}
+	return list, nil
+}
+
+func readRrdInfo(path string) (*rrdInfo, error) {
+	info, err := rrd.Info(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rrd info: %s", err)
+	}
+	ri := new(rrdInfo)
+	if ri.Step, err = infoInt(info["step"]); err != nil {
+		return nil, fmt.Errorf("invalid step in rrd info: %s", err)
+	}
+	if ri.LastUpdate, err = infoInt(info["last_update"]); err != nil {
+		return nil, fmt.Errorf("invalid last_update in rrd info: %s", err)
+	}
+
+	cfs, err := infoList(info, "rra.cf")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := infoList(info, "rra.rows")
+	if err != nil {
+		return nil, err
+	}
+	pdpPerRows, err := infoList(info, "rra.pdp_per_row")
+	if err != nil {
+		return nil, err
+	}
+	if len(cfs) != len(rows) || len(cfs) != len(pdpPerRows) {
+		return nil, fmt.Errorf("inconsistent rra definitions in rrd info")
+	}
+
+	ri.Archives = make([]*rrdArchive, len(cfs))
+	for i := range cfs {
+		rra := &rrdArchive{}
+		if rra.Cf, _ = cfs[i].(string); rra.Cf == "" {
+			return nil, fmt.Errorf("invalid cf of rra %d", i)
+		}
+		if rra.Rows, err = infoInt(rows[i]); err != nil {
+			return nil, fmt.Errorf("invalid row count of rra %d: %s", i, err)
+		}
+		if rra.PdpPerRow, err = infoInt(pdpPerRows[i]); err != nil {
+			return nil, fmt.Errorf("invalid pdp_per_row of rra %d: %s", i, err)
+		}
+		rra.Step = rra.PdpPerRow * ri.Step
+		ri.Archives[i] = rra
+	}
+
+	return ri, nil
+}
+
+// readRrdSetInfo reads the rrd info of all rrd files of the rrd set
+// The rrd files of a MULTIPLE rrd set must have the same archives, the newest last update is used.
+func readRrdSetInfo(rrdSet *rrdpath.RrdSet) (*rrdInfo, error) {
+	if rrdSet.StorageType != rrdpath.StorageMultiple {
+		return readRrdInfo(rrdSet.RrdPath)
+	}
+	var ri *rrdInfo
+	for _, path := range rrdSet.RrdFiles {
+		info, err := readRrdInfo(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if ri == nil {
+			ri = info
+			continue
+		}
+		if !ri.sameArchives(info) {
+			return nil, fmt.Errorf("rrd file %s has other archives than %s", path, rrdSet.RrdFiles[0])
+		}
+		if info.LastUpdate > ri.LastUpdate {
+			ri.LastUpdate = info.LastUpdate
+		}
+	}
+	if ri == nil {
+		return nil, fmt.Errorf("rrd set has no rrd files")
+	}
+	return ri, nil
+}
+
+func (ri *rrdInfo) sameArchives(other *rrdInfo) bool {
+	if ri.Step != other.Step || len(ri.Archives) != len(other.Archives) {
+		return false
+	}
+	for i, rra := range ri.Archives {
+		if *rra != *other.Archives[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// archives returns all archives of the consolidation function cf ordered from fine to coarse
+func (ri *rrdInfo) archives(cf string) []*rrdArchive {
+	result := make([]*rrdArchive, 0, len(ri.Archives))
+	for _, rra := range ri.Archives {
+		if rra.Cf == cf {
+			result = append(result, rra)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Step < result[j].Step
+	})
+	return result
+}
+
+// retentions builds whisper retentions that match the archives of the consolidation function cf
+// Archives that can not be represented in whisper (same precision, precision that does not divide
+// evenly, not covering a larger time interval) are skipped.
+func (ri *rrdInfo) retentions(cf string) (whisper.Retentions, error) {
+	var prev *rrdArchive
+	retentions := make(whisper.Retentions, 0)
+	for _, rra := range ri.archives(cf) {
+		if rra.Step <= 0 || rra.Rows <= 0 {
+			continue
+		}
+		if prev != nil {
+			if rra.Step == prev.Step {
+				if rra.Rows > prev.Rows {
+					prev.Rows = rra.Rows
+					retentions[len(retentions)-1] = newArchiveRetention(prev)
+				}
+				continue
+			}
+			if rra.Step%prev.Step != 0 ||
+				rra.Step*rra.Rows <= prev.Step*prev.Rows ||
+				prev.Rows < rra.Step/prev.Step {
+				continue
+			}
+		}
+		// copy, so we don't modify the rrd info while merging archives with the same step
+		rraCopy := *rra
+		prev = &rraCopy
+		retentions = append(retentions, newArchiveRetention(prev))
+	}
+	if len(retentions) == 0 {
+		return nil, fmt.Errorf("rrd file has no usable %s rra", cf)
+	}
+	return retentions, nil
+}
+
+func newArchiveRetention(rra *rrdArchive) *whisper.Retention {
+	retention := whisper.NewRetention(rra.Step, rra.Rows)
+	return &retention
+}
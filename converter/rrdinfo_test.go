@@ -0,0 +1,36 @@
+package converter
+
+import (
+	"testing"
+)
+
+func TestRrdInfoRetentions(t *testing.T) {
+	info := &rrdInfo{
+		Step: 300,
+		Archives: []*rrdArchive{
+			{Cf: "AVERAGE", PdpPerRow: 12, Rows: 744, Step: 3600},
+			{Cf: "AVERAGE", PdpPerRow: 1, Rows: 2880, Step: 300},
+			{Cf: "MAX", PdpPerRow: 1, Rows: 2880, Step: 300},
+			{Cf: "AVERAGE", PdpPerRow: 7, Rows: 10, Step: 2100},
+			{Cf: "AVERAGE", PdpPerRow: 288, Rows: 1825, Step: 86400},
+		},
+	}
+
+	retentions, err := info.retentions("AVERAGE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]int{{300, 2880}, {3600, 744}, {86400, 1825}}
+	if len(retentions) != len(expected) {
+		t.Fatalf("expected %d retentions, got %d: %v", len(expected), len(retentions), retentions)
+	}
+	for i, exp := range expected {
+		if retentions[i].SecondsPerPoint() != exp[0] || retentions[i].NumberOfPoints() != exp[1] {
+			t.Errorf("retention %d: expected %d:%d, got %s", i, exp[0], exp[1], retentions[i])
+		}
+	}
+
+	if _, err := info.retentions("MIN"); err == nil {
+		t.Error("expected error for missing MIN rra")
+	}
+}
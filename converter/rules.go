@@ -0,0 +1,225 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/go-ini/ini"
+)
+
+// AggregationRule is one section of a carbon storage-aggregation.conf
+// A nil Unit matches datasources with every unit.
+type AggregationRule struct {
+	Name              string
+	Pattern           *regexp.Regexp
+	Unit              *regexp.Regexp
+	AggregationMethod whisper.AggregationMethod
+	XFilesFactor      float32
+	hasMethod         bool
+	hasXFilesFactor   bool
+}
+
+// AggregationRules are matched in order, the first matching rule wins
+type AggregationRules []*AggregationRule
+
+func parseAggregationMethod(method string) (whisper.AggregationMethod, error) {
+	switch strings.ToLower(method) {
+	case "average", "avg":
+		return whisper.Average, nil
+	case "sum":
+		return whisper.Sum, nil
+	case "last":
+		return whisper.Last, nil
+	case "max":
+		return whisper.Max, nil
+	case "min":
+		return whisper.Min, nil
+	case "first":
+		return whisper.First, nil
+	}
+	return 0, fmt.Errorf("unsupported aggregation method \"%s\"", method)
+}
+
+func loadRulesFile(path string) (*ini.File, error) {
+	// patterns are regular expressions and may contain ; and #
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// skipSection skips the DEFAULT section, it is no rule in carbon either
+func skipSection(sec *ini.Section) bool {
+	return sec.Name() == ini.DefaultSection
+}
+
+// ruleKey returns the key case insensitive like python's ConfigParser used by carbon
+func ruleKey(sec *ini.Section, name string) *ini.Key {
+	for _, key := range sec.Keys() {
+		if strings.EqualFold(key.Name(), name) {
+			return key
+		}
+	}
+	return nil
+}
+
+func rulePattern(sec *ini.Section) (*regexp.Regexp, error) {
+	key := ruleKey(sec, "pattern")
+	if key == nil {
+		return nil, fmt.Errorf("section [%s] has no pattern", sec.Name())
+	}
+	pattern, err := regexp.Compile(key.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern in section [%s]: %s", sec.Name(), err)
+	}
+	return pattern, nil
+}
+
+// LoadAggregationRules reads a carbon storage-aggregation.conf
+// In addition to carbon a section may have a unit regexp that is matched against the unit of the datasource.
+func LoadAggregationRules(path string) (AggregationRules, error) {
+	cfg, err := loadRulesFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(AggregationRules, 0)
+	for _, sec := range cfg.Sections() {
+		if skipSection(sec) {
+			continue
+		}
+		rule := &AggregationRule{Name: sec.Name()}
+		if rule.Pattern, err = rulePattern(sec); err != nil {
+			return nil, err
+		}
+		if key := ruleKey(sec, "unit"); key != nil {
+			if rule.Unit, err = regexp.Compile(key.String()); err != nil {
+				return nil, fmt.Errorf("invalid unit in section [%s]: %s", sec.Name(), err)
+			}
+		}
+		if key := ruleKey(sec, "aggregationMethod"); key != nil {
+			if rule.AggregationMethod, err = parseAggregationMethod(key.String()); err != nil {
+				return nil, fmt.Errorf("invalid aggregationMethod in section [%s]: %s", sec.Name(), err)
+			}
+			rule.hasMethod = true
+		}
+		if key := ruleKey(sec, "xFilesFactor"); key != nil {
+			xff, err := key.Float64()
+			if err != nil || xff < 0 || xff > 1 {
+				return nil, fmt.Errorf("invalid xFilesFactor in section [%s]", sec.Name())
+			}
+			rule.XFilesFactor = float32(xff)
+			rule.hasXFilesFactor = true
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Match returns the first rule matching the metric name and unit or nil
+func (rules AggregationRules) Match(metric, unit string) *AggregationRule {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(metric) && (rule.Unit == nil || rule.Unit.MatchString(unit)) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Aggregation returns the aggregation method and xFilesFactor for the metric name and unit
+// If no rule matches or the rule does not set a value, the defaults are returned.
+func (rules AggregationRules) Aggregation(metric, unit string, method whisper.AggregationMethod, xFilesFactor float32) (whisper.AggregationMethod, float32) {
+	rule := rules.Match(metric, unit)
+	if rule == nil {
+		return method, xFilesFactor
+	}
+	if rule.hasMethod {
+		method = rule.AggregationMethod
+	}
+	if rule.hasXFilesFactor {
+		xFilesFactor = rule.XFilesFactor
+	}
+	return method, xFilesFactor
+}
+
+// SchemaRule is one section of a carbon storage-schemas.conf
+type SchemaRule struct {
+	Name       string
+	Pattern    *regexp.Regexp
+	Retentions whisper.Retentions
+}
+
+// SchemaRules are matched in order, the first matching rule wins
+type SchemaRules []*SchemaRule
+
+// parseCarbonRetentions parses a retention list like carbon does
+// In contrast to whisper.ParseRetentionDefs a number without unit is the number of points.
+func parseCarbonRetentions(retentionDefs string) (whisper.Retentions, error) {
+	retentions := make(whisper.Retentions, 0)
+	for _, retentionDef := range strings.Split(retentionDefs, ",") {
+		retentionDef = strings.TrimSpace(retentionDef)
+		retention, err := whisper.ParseRetentionDef(retentionDef)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(retentionDef, ":")
+		if points, err := strconv.Atoi(parts[1]); err == nil {
+			r := whisper.NewRetention(retention.SecondsPerPoint(), points)
+			retention = &r
+		}
+		retentions = append(retentions, retention)
+	}
+	return retentions, nil
+}
+
+// LoadSchemaRules reads a carbon storage-schemas.conf
+func LoadSchemaRules(path string) (SchemaRules, error) {
+	cfg, err := loadRulesFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(SchemaRules, 0)
+	for _, sec := range cfg.Sections() {
+		if skipSection(sec) {
+			continue
+		}
+		rule := &SchemaRule{Name: sec.Name()}
+		if rule.Pattern, err = rulePattern(sec); err != nil {
+			return nil, err
+		}
+		key := ruleKey(sec, "retentions")
+		if key == nil {
+			return nil, fmt.Errorf("section [%s] has no retentions", sec.Name())
+		}
+		if rule.Retentions, err = parseCarbonRetentions(key.String()); err != nil {
+			return nil, fmt.Errorf("invalid retentions in section [%s]: %s", sec.Name(), err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Match returns the first rule matching the metric name or nil
+func (rules SchemaRules) Match(metric string) *SchemaRule {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(metric) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Retentions returns the retentions for the metric name or the default if no rule matches
+func (rules SchemaRules) Retentions(metric string, retentions whisper.Retentions) whisper.Retentions {
+	if rule := rules.Match(metric); rule != nil {
+		return rule.Retentions
+	}
+	return retentions
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+func writeRulesFile(t *testing.T, content string) string {
+	fl, err := ioutil.TempFile("", "rrd2whisper-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	if _, err := fl.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return fl.Name()
+}
+
+func TestAggregationRules(t *testing.T) {
+	path := writeRulesFile(t, `# comment
+[min]
+pattern = \.min$
+xfilesfactor = 0.1
+aggregationMethod = min
+
+[percent]
+pattern = ^host3\.
+unit = ^%$
+aggregationMethod = max
+
+[counters]
+pattern = ^host1\.[^;]+\.(count|uptime)$
+aggregationMethod = sum
+
+[default_average]
+pattern = .*
+xFilesFactor = 0.3
+`)
+	defer os.Remove(path)
+
+	rules, err := LoadAggregationRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, found %d", len(rules))
+	}
+
+	tests := []struct {
+		metric string
+		unit   string
+		method whisper.AggregationMethod
+		xff    float32
+	}{
+		{"host1.service1.label.min", "", whisper.Min, 0.1},
+		{"host1.service1.uptime", "s", whisper.Sum, 0.5},
+		{"host2.service1.uptime", "", whisper.Average, 0.3},
+		{"host3.service1.pl", "%", whisper.Max, 0.5},
+		{"host3.service1.rta", "ms", whisper.Average, 0.3},
+	}
+	for _, test := range tests {
+		method, xff := rules.Aggregation(test.metric, test.unit, whisper.Average, 0.5)
+		if method != test.method || xff != test.xff {
+			t.Errorf("%s: expected %s %f, got %s %f", test.metric, test.method, test.xff, method, xff)
+		}
+	}
+
+	var empty AggregationRules
+	if method, xff := empty.Aggregation("host1.service1.label", "", whisper.Max, 0.5); method != whisper.Max || xff != 0.5 {
+		t.Errorf("empty rules must return the defaults")
+	}
+}
+
+func TestAggregationRulesInvalid(t *testing.T) {
+	for _, content := range []string{
+		"[a]\naggregationMethod = sum\n",
+		"[a]\npattern = (\n",
+		"[a]\npattern = .*\nunit = (\n",
+		"[a]\npattern = .*\naggregationMethod = median\n",
+		"[a]\npattern = .*\nxFilesFactor = 2\n",
+	} {
+		path := writeRulesFile(t, content)
+		if _, err := LoadAggregationRules(path); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+		os.Remove(path)
+	}
+}
+
+func TestSchemaRules(t *testing.T) {
+	path := writeRulesFile(t, `[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[ping]
+pattern = ^[^.]+\.ping\.
+retentions = 60s:1d, 5m:90d
+
+[default]
+pattern = .*
+retentions = 60:1440,3600:17520
+`)
+	defer os.Remove(path)
+
+	rules, err := LoadSchemaRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, found %d", len(rules))
+	}
+
+	tests := []struct {
+		metric   string
+		expected [][2]int
+	}{
+		{"host1.ping.rta", [][2]int{{60, 1440}, {300, 25920}}},
+		{"host1.disk.used", [][2]int{{60, 1440}, {3600, 17520}}},
+	}
+	for _, test := range tests {
+		retentions := rules.Retentions(test.metric, nil)
+		if len(retentions) != len(test.expected) {
+			t.Fatalf("%s: expected %d retentions, got %d", test.metric, len(test.expected), len(retentions))
+		}
+		for i, exp := range test.expected {
+			if retentions[i].SecondsPerPoint() != exp[0] || retentions[i].NumberOfPoints() != exp[1] {
+				t.Errorf("%s: retention %d: expected %d:%d, got %s", test.metric, i, exp[0], exp[1], retentions[i])
+			}
+		}
+	}
+
+	var empty SchemaRules
+	if retentions := empty.Retentions("host1.ping.rta", rules[0].Retentions); len(retentions) != 1 {
+		t.Errorf("empty rules must return the default retention")
+	}
+}
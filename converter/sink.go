@@ -0,0 +1,100 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// sinkBatchSize is the number of points per metric passed to Sink.Send at once
+const sinkBatchSize = 1000
+
+// SinkMetric identifies the metric of the points passed to a Sink
+type SinkMetric struct {
+	// Name is the graphite metric name <host>.<service>.<label>
+	Name        string
+	Hostname    string
+	Servicename string
+	Label       string
+}
+
+// Sink receives the converted data instead of whisper files
+// A Sink is shared by all workers and must be safe for concurrent use.
+type Sink interface {
+	Send(ctx context.Context, metric *SinkMetric, points []*whisper.TimeSeriesPoint) error
+	// Flush returns after the data sent for the service was delivered, the rrd set is
+	// recorded as converted only then
+	Flush(ctx context.Context, hostname, servicename string) error
+	// Close sends all pending data and releases the resources of the sink
+	Close() error
+}
+
+// stream sends the rows of the rrd file to the sink
+func (cvt *Converter) stream(ctx context.Context, rrdSet *rrdpath.RrdSet, entry *rrdpath.JournalEntry) error {
+	metrics := make([]*SinkMetric, len(rrdSet.Datasources))
+	batches := make([][]*whisper.TimeSeriesPoint, len(rrdSet.Datasources))
+	for i, label := range rrdSet.Datasources {
+		metric, err := cvt.metric(rrdSet, i, label, "")
+		if err != nil {
+			return err
+		}
+		metrics[i] = &SinkMetric{
+			Name:        metric,
+			Hostname:    rrdSet.Hostname,
+			Servicename: rrdSet.Servicename,
+			Label:       replaceIllegalCharacters(label),
+		}
+		batches[i] = make([]*whisper.TimeSeriesPoint, 0, sinkBatchSize)
+	}
+
+	// the dumper stops and frees the rrd file if the stream returns before all rows are read
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dumperHelper, err := NewRrdDumperHelper(dumpCtx, rrdSet.RrdFiles...)
+	if err != nil {
+		return err
+	}
+
+	for row := range dumperHelper.Results() {
+		if len(row.Values) != len(metrics) {
+			return fmt.Errorf("invalid number of values in rrd %d != xml %d", len(row.Values), len(metrics))
+		}
+		ts := int(row.Time.Unix())
+		for i, value := range row.Values {
+			if math.IsNaN(value) {
+				continue
+			}
+			batches[i] = append(batches[i], &whisper.TimeSeriesPoint{Time: ts, Value: value})
+			entry.Points++
+			if len(batches[i]) == sinkBatchSize {
+				if err := cvt.Sink.Send(ctx, metrics[i], batches[i]); err != nil {
+					return err
+				}
+				batches[i] = make([]*whisper.TimeSeriesPoint, 0, sinkBatchSize)
+			}
+		}
+	}
+
+	// Check if canceld while dumping
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	for i, batch := range batches {
+		if len(batch) > 0 {
+			if err := cvt.Sink.Send(ctx, metrics[i], batch); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cvt.Sink.Flush(ctx, rrdSet.Hostname, rrdSet.Servicename); err != nil {
+		return err
+	}
+
+	return cvt.finish(rrdSet, entry)
+}
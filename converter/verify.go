@@ -0,0 +1,218 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// VerifyResult is the comparison of one whisper file with the datasource of the rrd file
+type VerifyResult struct {
+	Metric string
+	Path   string
+	// Expected is the number of rrd values within the retention of the whisper file
+	Expected int
+	// Expired is the number of rrd values older than the retention of the whisper file
+	Expired    int
+	Missing    int
+	Mismatched int
+	// MaxDeviation is the largest absolute difference of a value
+	MaxDeviation float64
+	Err          error
+	// Merged is the merge strategy recorded in the journal if an existing whisper file was merged
+	Merged string
+}
+
+// Coverage returns the fraction of the expected values found in the whisper file
+func (vr *VerifyResult) Coverage() float64 {
+	if vr.Expected == 0 {
+		return 1
+	}
+	return float64(vr.Expected-vr.Missing) / float64(vr.Expected)
+}
+
+// OK checks if all expected values were found and matched
+func (vr *VerifyResult) OK() bool {
+	return vr.Err == nil && vr.Missing == 0 && vr.Mismatched == 0
+}
+
+// MergedMismatch checks if the values don't match because the existing whisper file was
+// merged with MergePreferWhisper, which overwrites the rrd values
+func (vr *VerifyResult) MergedMismatch() bool {
+	return vr.Err == nil && vr.Mismatched > 0 && vr.Missing == 0 && vr.Merged == string(MergePreferWhisper)
+}
+
+// String formats the result as a single line
+func (vr *VerifyResult) String() string {
+	state := "ok"
+	if vr.MergedMismatch() {
+		state = "MERGED"
+	} else if !vr.OK() {
+		state = "FAIL"
+	}
+	if vr.Err != nil {
+		return fmt.Sprintf("%-4s %s: %s", state, vr.Metric, vr.Err)
+	}
+	return fmt.Sprintf("%-4s %s coverage=%.2f%% expected=%d missing=%d mismatched=%d expired=%d max_deviation=%g",
+		state, vr.Metric, vr.Coverage()*100, vr.Expected, vr.Missing, vr.Mismatched, vr.Expired, vr.MaxDeviation)
+}
+
+// verifyPoints holds the values of one datasource of the rrd file
+type verifyPoints struct {
+	times  []int
+	values []float64
+}
+
+// Verify compares the whisper files of the rrd set with the finest rra of each consolidation
+// The AVERAGE files are compared and with MinMax the .min and .max files, the coarser rra's are
+// not compared. A value matches if it differs by at most tolerance relative to the rrd value
+// (absolute for values below 1). The merge strategy recorded in the journal is set in the results.
+func (cvt *Converter) Verify(ctx context.Context, rrdSet *rrdpath.RrdSet, tolerance float64) ([]*VerifyResult, error) {
+	if _, err := cvt.resolveLabels(rrdSet); err != nil {
+		return nil, err
+	}
+	info, err := readRrdSetInfo(rrdSet)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string)
+	if entry := rrdSet.Entry(); entry != nil {
+		for _, fl := range entry.Files {
+			merged[fl.Path] = fl.Merged
+		}
+	}
+
+	results := make([]*VerifyResult, 0, len(rrdSet.Datasources))
+	for _, c := range cvt.consolidations(rrdSet, info) {
+		consolidationResults, err := cvt.verifyConsolidation(ctx, rrdSet, info, c, tolerance)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range consolidationResults {
+			result.Merged = merged[result.Path]
+		}
+		results = append(results, consolidationResults...)
+	}
+	return results, nil
+}
+
+// verifyConsolidation compares the whisper files of one consolidation with its finest rra
+func (cvt *Converter) verifyConsolidation(ctx context.Context, rrdSet *rrdpath.RrdSet, info *rrdInfo, c *consolidation, tolerance float64) ([]*VerifyResult, error) {
+	rras := info.archives(c.Cf)
+	if len(rras) == 0 {
+		return nil, fmt.Errorf("rrd file has no %s rra", c.Cf)
+	}
+
+	dumperHelper, step, err := newRrdArchiveDumperHelper(ctx, rrdSet.RrdFiles, rras[0], info.LastUpdate)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]verifyPoints, len(rrdSet.Datasources))
+	for row := range dumperHelper.Results() {
+		if len(row.Values) != len(points) {
+			// drain the results so the dumper can finish
+			for range dumperHelper.Results() {
+			}
+			return nil, fmt.Errorf("invalid number of values in rrd %d != xml %d", len(row.Values), len(points))
+		}
+		ts := int(row.Time.Unix())
+		for i, value := range row.Values {
+			if !math.IsNaN(value) {
+				points[i].times = append(points[i].times, ts)
+				points[i].values = append(points[i].values, value)
+			}
+		}
+	}
+
+	// Check if canceld while dumping
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	results := make([]*VerifyResult, len(rrdSet.Datasources))
+	for i, label := range rrdSet.Datasources {
+		paths, err := cvt.metricPaths(rrdSet, i, label, c.Suffix)
+		if err != nil {
+			return nil, err
+		}
+		result := &VerifyResult{
+			Metric: paths.Metric,
+			Path:   paths.Destination,
+		}
+		result.Err = result.compare(&points[i], step, tolerance)
+		results[i] = result
+	}
+	return results, nil
+}
+
+// compare fetches the whisper values at the times of the rrd values
+func (vr *VerifyResult) compare(points *verifyPoints, step int, tolerance float64) error {
+	if _, err := os.Stat(vr.Path); err != nil {
+		return fmt.Errorf("whisper file missing: %s", err)
+	}
+	ws, err := whisper.Open(vr.Path)
+	if err != nil {
+		return fmt.Errorf("could not open whisper file: %s", err)
+	}
+	defer ws.Close()
+	if len(points.times) == 0 {
+		return nil
+	}
+
+	// Use the longest archive that can hold every row of the rra, coarser archives contain aggregated values
+	maxRetention := 0
+	for _, retention := range ws.Retentions() {
+		if step%retention.SecondsPerPoint() == 0 && retention.MaxRetention() > maxRetention {
+			maxRetention = retention.MaxRetention()
+		}
+	}
+	if maxRetention == 0 {
+		return fmt.Errorf("whisper file has no archive with a precision of %ds or finer", step)
+	}
+
+	first := points.times[0]
+	last := points.times[len(points.times)-1]
+	from := first - 1
+	if oldest := int(time.Now().Unix()) - maxRetention; from < oldest {
+		from = oldest
+	}
+	var timeSeries *whisper.TimeSeries
+	if from < last {
+		if timeSeries, err = ws.Fetch(from, last+step); err != nil {
+			return fmt.Errorf("could not fetch data from whisper file: %s", err)
+		}
+	}
+	if timeSeries == nil {
+		vr.Expired = len(points.times)
+		return nil
+	}
+
+	values := timeSeries.Values()
+	for i, ts := range points.times {
+		if ts < timeSeries.FromTime() || ts >= timeSeries.UntilTime() {
+			vr.Expired++
+			continue
+		}
+		vr.Expected++
+		value := values[(ts-timeSeries.FromTime())/timeSeries.Step()]
+		if math.IsNaN(value) {
+			vr.Missing++
+			continue
+		}
+		deviation := math.Abs(value - points.values[i])
+		if deviation > vr.MaxDeviation {
+			vr.MaxDeviation = deviation
+		}
+		if deviation > tolerance*math.Max(1, math.Abs(points.values[i])) {
+			vr.Mismatched++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package converter
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/go-whisper"
+)
+
+func TestVerifyCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "label1.wsp")
+	retention, _ := whisper.ParseRetentionDefs("60s:1h,300s:1d")
+	ws, err := whisper.Create(path, retention, whisper.Average, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := int(time.Now().Unix())
+	now -= now % 300
+	points := &verifyPoints{}
+	wspPoints := make([]*whisper.TimeSeriesPoint, 0)
+	for i := 10; i > 0; i-- {
+		ts := now - i*60
+		points.times = append(points.times, ts)
+		points.values = append(points.values, float64(i))
+		switch i {
+		case 2:
+			// missing
+		case 3:
+			wspPoints = append(wspPoints, &whisper.TimeSeriesPoint{Time: ts, Value: float64(i) + 0.5})
+		default:
+			wspPoints = append(wspPoints, &whisper.TimeSeriesPoint{Time: ts, Value: float64(i)})
+		}
+	}
+	// older than the 60s archive
+	points.times = append([]int{now - 2*3600}, points.times...)
+	points.values = append([]float64{1}, points.values...)
+	if err := ws.UpdateMany(wspPoints); err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	vr := &VerifyResult{Metric: "host1.service1.label1", Path: path}
+	if err := vr.compare(points, 60, 0.0001); err != nil {
+		t.Fatal(err)
+	}
+	if vr.Expected != 10 || vr.Expired != 1 || vr.Missing != 1 || vr.Mismatched != 1 || vr.MaxDeviation != 0.5 {
+		t.Errorf("unexpected result %s", vr)
+	}
+	if vr.OK() || math.Abs(vr.Coverage()-0.9) > 1e-9 || !strings.HasPrefix(vr.String(), "FAIL") {
+		t.Errorf("unexpected result %s", vr)
+	}
+
+	if vr.MergedMismatch() {
+		t.Error("a missing value is no merged mismatch")
+	}
+	mismatch := &VerifyResult{Metric: "host1.service1.label1", Mismatched: 1, Merged: string(MergeFillGaps)}
+	if mismatch.MergedMismatch() {
+		t.Error("fill-gaps keeps the rrd values")
+	}
+	mismatch.Merged = string(MergePreferWhisper)
+	if !mismatch.MergedMismatch() || !strings.HasPrefix(mismatch.String(), "MERGED") {
+		t.Errorf("unexpected result %s", mismatch)
+	}
+
+	vr = &VerifyResult{Metric: "host1.service1.label1", Path: path}
+	if err := vr.compare(points, 90, 0.0001); err == nil {
+		t.Error("expected error for incompatible step")
+	}
+	vr = &VerifyResult{Metric: "host1.service1.label2", Path: filepath.Join(dir, "label2.wsp")}
+	if err := vr.compare(points, 60, 0.0001); err == nil {
+		t.Error("expected error for missing whisper file")
+	}
+}
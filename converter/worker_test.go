@@ -2,6 +2,8 @@ package converter
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
@@ -41,7 +43,7 @@ func TestWorker(t *testing.T) {
 	testsuite.CreateRrd(ts.Source, "host1", "service1", pf, time.Now().Add(-testsuite.DAY), time.Now(), false)
 
 	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
-	workdata, err := rrdpath.NewWorkdata(rrdPath, oldest, 0)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,7 +83,7 @@ func TestWorkerDelete(t *testing.T) {
 	}
 
 	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
-	workdata, err := rrdpath.NewWorkdata(rrdPath, oldest, 0)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -125,7 +127,7 @@ func TestWorkerNoDelete(t *testing.T) {
 	}
 
 	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
-	workdata, err := rrdpath.NewWorkdata(rrdPath, oldest, 0)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -168,7 +170,7 @@ func TestWorkerCancel(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	rrdPath := rrdpath.Walk(ctx, ts.Source)
-	workdata, err := rrdpath.NewWorkdata(rrdPath, oldest, 0)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,7 +203,7 @@ func TestWorkerEmpty(t *testing.T) {
 	var oldest time.Time // == 0
 
 	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
-	workdata, err := rrdpath.NewWorkdata(rrdPath, oldest, 0)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -216,3 +218,156 @@ func TestWorkerEmpty(t *testing.T) {
 	NewWorker(context.Background(), &wg, workdata.RrdSets, 1, cvt, vs)
 	wg.Wait()
 }
+
+func TestWorkerMinMax(t *testing.T) {
+	ts := testsuite.Prepare()
+	defer ts.Shutdown()
+
+	SetRetention("60s:365d")
+
+	pf, err := perfdata.ParsePerfdata("label1=0%;0;0;0; 'labe l2'=34")
+	if err != nil {
+		panic(err)
+	}
+
+	var oldest time.Time // == 0
+
+	testsuite.CreateRrd(ts.Source, "host1", "service1", pf, time.Now().Add(-testsuite.DAY), time.Now(), false)
+
+	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := &testWorkerVisitor{
+		errors: make([]error, 0),
+	}
+
+	var wg sync.WaitGroup
+
+	cvt := &Converter{Destination: ts.Destination, ArchivePath: ts.Archive, TempPath: ts.Temp, Merge: true, MinMax: true, UUIDToPerfdata: make(oitcdb.UUIDToPerfdata), DeleteRRD: false}
+	NewWorker(context.Background(), &wg, workdata.RrdSets, 1, cvt, vs)
+	wg.Wait()
+	if len(vs.errors) != 0 {
+		for i := 0; i < len(vs.errors); i++ {
+			t.Error(vs.errors[i])
+		}
+	}
+
+	for _, name := range []string{"label1", "label1.min", "label1.max", "labe_l2", "labe_l2.min", "labe_l2.max"} {
+		if _, err := os.Stat(fmt.Sprintf("%s/host1/service1/%s.wsp", ts.Destination, name)); os.IsNotExist(err) {
+			t.Errorf("%s.wsp does not exist", name)
+		}
+	}
+}
+
+func TestWorkerMergeError(t *testing.T) {
+	ts := testsuite.Prepare()
+	defer ts.Shutdown()
+
+	SetRetention("60s:365d")
+
+	pf, err := perfdata.ParsePerfdata("label1=0%;0;0;0; 'labe l2'=34")
+	if err != nil {
+		panic(err)
+	}
+
+	var oldest time.Time // == 0
+
+	testsuite.CreateRrd(ts.Source, "host1", "service1", pf, time.Now().Add(-testsuite.DAY), time.Now(), false)
+
+	// a broken whisper file can't be merged
+	destdir := fmt.Sprintf("%s/host1/service1", ts.Destination)
+	if err := os.MkdirAll(destdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"label1", "labe_l2"} {
+		if err := ioutil.WriteFile(fmt.Sprintf("%s/%s.wsp", destdir, name), []byte("broken"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := &testWorkerVisitor{
+		errors: make([]error, 0),
+	}
+
+	var wg sync.WaitGroup
+
+	cvt := &Converter{Destination: ts.Destination, ArchivePath: ts.Archive, TempPath: ts.Temp, Merge: true, UUIDToPerfdata: make(oitcdb.UUIDToPerfdata)}
+	NewWorker(context.Background(), &wg, workdata.RrdSets, 1, cvt, vs)
+	wg.Wait()
+	if len(vs.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(vs.errors))
+	}
+
+	for _, name := range []string{"label1", "labe_l2"} {
+		data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.wsp", destdir, name))
+		if err != nil || string(data) != "broken" {
+			t.Errorf("destination file %s was modified", name)
+		}
+		if _, err := os.Stat(fmt.Sprintf("%s/host1/service1/%s.wsp", ts.Archive, name)); !os.IsNotExist(err) {
+			t.Errorf("destination file %s was archived", name)
+		}
+	}
+	if !workdata.RrdSets[0].Todo() {
+		t.Error("failed rrd set must not be marked as done")
+	}
+}
+
+func TestWorkerMultiple(t *testing.T) {
+	ts := testsuite.Prepare()
+	defer ts.Shutdown()
+
+	SetRetention("60s:365d")
+
+	pf, err := perfdata.ParsePerfdata("label1=0%;0;0;0; 'labe l2'=34")
+	if err != nil {
+		panic(err)
+	}
+
+	var oldest time.Time // == 0
+
+	testData := testsuite.CreateMultipleRrd(ts.Source, "host1", "service1", pf, time.Now().Add(-testsuite.DAY), time.Now())
+
+	rrdPath := rrdpath.Walk(context.Background(), ts.Source)
+	workdata, err := rrdpath.NewWorkdata(rrdPath, nil, oldest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workdata.RrdSets) != 1 {
+		t.Fatalf("found %d rrd sets instead of 1", len(workdata.RrdSets))
+	}
+
+	vs := &testWorkerVisitor{
+		errors: make([]error, 0),
+	}
+
+	var wg sync.WaitGroup
+
+	cvt := &Converter{Destination: ts.Destination, ArchivePath: ts.Archive, TempPath: ts.Temp, Merge: true, MinMax: true, UUIDToPerfdata: make(oitcdb.UUIDToPerfdata), DeleteRRD: true}
+	NewWorker(context.Background(), &wg, workdata.RrdSets, 1, cvt, vs)
+	wg.Wait()
+	if len(vs.errors) != 0 {
+		for i := 0; i < len(vs.errors); i++ {
+			t.Error(vs.errors[i])
+		}
+	}
+
+	for _, name := range []string{"label1", "label1.min", "label1.max", "labe_l2", "labe_l2.min", "labe_l2.max"} {
+		if _, err := os.Stat(fmt.Sprintf("%s/host1/service1/%s.wsp", ts.Destination, name)); os.IsNotExist(err) {
+			t.Errorf("%s.wsp does not exist", name)
+		}
+	}
+	for _, path := range testData.Files {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("rrd file %s still exists", path)
+		}
+	}
+}
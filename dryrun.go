@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-graphite/go-whisper"
+	"github.com/it-novum/rrd2whisper/converter"
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// planSink tells Converter.Plan that the data would be sent to a sink, nothing is sent to it
+type planSink struct{}
+
+func (planSink) Send(context.Context, *converter.SinkMetric, []*whisper.TimeSeriesPoint) error {
+	return nil
+}
+
+func (planSink) Flush(context.Context, string, string) error {
+	return nil
+}
+
+func (planSink) Close() error {
+	return nil
+}
+
+// dryRun prints the planned operations for every rrd set
+func dryRun(cvt *converter.Converter, rrdSets []*rrdpath.RrdSet) {
+	failed := 0
+	for _, rrdSet := range rrdSets {
+		plan := cvt.Plan(rrdSet)
+		if plan.Err != nil {
+			failed++
+		}
+		fmt.Print(plan)
+		logging.Log("dry run %s", plan)
+	}
+	logging.LogDisplay("Dry run finished: %d of %d rrd files would fail", failed, len(rrdSets))
+}
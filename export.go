@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/converter"
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+// rrdSetFilter selects rrd sets by host and service name, empty names match all
+type rrdSetFilter struct {
+	host    string
+	service string
+}
+
+func (filter *rrdSetFilter) addFlags(fs *flag.FlagSet, command string) {
+	fs.StringVar(&filter.host, "host", "", fmt.Sprintf("only %s the rrd files of this host (directory name)", command))
+	fs.StringVar(&filter.service, "service", "", fmt.Sprintf("only %s the rrd file of this service (file name without extension)", command))
+}
+
+// match checks if the rrd set was selected with -host and -service
+func (filter *rrdSetFilter) match(rrdSet *rrdpath.RrdSet) bool {
+	if filter.host != "" && rrdSet.Hostname != filter.host {
+		return false
+	}
+	if filter.service != "" && rrdSet.Servicename != filter.service {
+		return false
+	}
+	return true
+}
+
+type exportCommandLine struct {
+	commandLine
+	filter rrdSetFilter
+	format string
+	output string
+}
+
+func parseExportCli(args []string) (*exportCommandLine, error) {
+	var err error
+
+	cli := new(exportCommandLine)
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [options]\n\nWrites the rows of the rrd files with the resolved datasource labels as csv or newline-delimited json.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cli.sourceDirectory, "source", "/opt/openitc/nagios/share/perfdata", "Path to source directory file tree of rrd files")
+	cli.filter.addFlags(fs, "export")
+	fs.StringVar(&cli.format, "format", "csv", "output format, either csv or json")
+	fs.StringVar(&cli.output, "output", "-", "Path to output file, - for stdout")
+	fs.Int64Var(&cli.maxAge, "max-age", 1209600, "Maximum age of an rrd file to be included (in seconds since last update, default 2 weeks, 0=all)")
+	fs.IntVar(&cli.limit, "limit", 0, "Limit number of rrd's, 0=unlimited")
+	fs.StringVar(&cli.logfile, "logfile", "/var/log/rrd2whisper.log", "Path to logfile")
+	addDatabaseFlags(fs, &cli.commandLine)
+	fs.Parse(args)
+
+	if cli.format != "csv" && cli.format != "json" {
+		return cli, fmt.Errorf("invalid export format \"%s\"", cli.format)
+	}
+	if _, err = os.Stat(cli.sourceDirectory); os.IsNotExist(err) {
+		return cli, fmt.Errorf("source directory does not exist")
+	}
+	if cli.sourceDirectory, err = filepath.Abs(cli.sourceDirectory); err != nil {
+		return cli, fmt.Errorf("could not get absolute path of source directory: %s", err)
+	}
+	if err = checkDatabaseFlags(&cli.commandLine); err != nil {
+		return cli, err
+	}
+
+	return cli, nil
+}
+
+// runExport is the main function of rrd2whisper export
+func runExport(args []string) {
+	// the data may be written to stdout
+	logging.PrintDisplayLog = func(message string) {
+		fmt.Fprintln(os.Stderr, message)
+	}
+
+	cli, err := parseExportCli(args)
+	if err != nil {
+		logging.LogFatal("%s", err)
+	}
+
+	lf, err := os.OpenFile(cli.logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logging.LogFatal("Could not open log file: %s", err)
+	}
+	defer lf.Close()
+	log.SetOutput(lf)
+
+	logging.Log("Version: %s", Version)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canSig := make(chan os.Signal, 1)
+	signal.Notify(canSig, os.Interrupt)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-canSig:
+			cancel()
+		}
+	}()
+
+	perfdata := loadPerfdata(ctx, &cli.commandLine)
+
+	var oldest time.Time
+	if cli.maxAge > 0 {
+		oldest = time.Now().Add(-time.Duration(cli.maxAge) * time.Second)
+	}
+	workdata, err := rrdpath.NewWorkdataFilter(rrdpath.Walk(ctx, cli.sourceDirectory), nil, oldest, cli.limit, cli.filter.match)
+	if err != nil {
+		logging.LogFatal("Could not scan rrd path: %s", err)
+	}
+	logging.LogDisplay("Exporting %d of %d rrd files", len(workdata.RrdSets), workdata.Total)
+
+	var out io.Writer = os.Stdout
+	if cli.output != "-" {
+		fl, err := os.OpenFile(cli.output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			logging.LogFatal("could not create export file: %s", err)
+		}
+		defer fl.Close()
+		out = fl
+	}
+
+	var rw converter.RowWriter
+	if cli.format == "json" {
+		rw = converter.NewJSONRowWriter(out)
+	} else {
+		rw = converter.NewCSVRowWriter(out)
+	}
+
+	cvt := &converter.Converter{UUIDToPerfdata: perfdata}
+	for _, rrdSet := range workdata.RrdSets {
+		if err := cvt.Export(ctx, rrdSet, rw); err != nil {
+			if ctx.Err() != nil {
+				logging.LogDisplay("export canceled")
+				return
+			}
+			logging.LogDisplay("could not export %s: %s", rrdSet.RrdPath, err)
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		logging.LogFatal("%s", err)
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/go-graphite/go-whisper v0.0.0-20200719145017-2157ac5ecda3
 	github.com/go-ini/ini v1.57.0
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/snappy v0.0.2
 	github.com/google/go-cmp v0.3.0 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20190430165422-3e4dfb77656c // indirect
 	github.com/jabdr/nagios-perfdata v1.0.0
@@ -0,0 +1,58 @@
+package oitcdb
+
+// ServiceName is the display name of a service and the uuid of its host
+type ServiceName struct {
+	HostUUID string `json:"host"`
+	Name     string `json:"name"`
+}
+
+// Names holds the display names of the hosts and services by uuid
+type Names struct {
+	Hosts    map[string]string       `json:"hosts"`
+	Services map[string]*ServiceName `json:"services"`
+}
+
+// QueryNames returns the display names of all hosts and services
+// The configuration tables are the same in openITCOCKPIT 3 and 4. Services without
+// a name use the name of their service template.
+func (oitc *OITC) QueryNames() (*Names, error) {
+	names := &Names{
+		Hosts:    make(map[string]string),
+		Services: make(map[string]*ServiceName),
+	}
+
+	rows, err := oitc.db.QueryContext(oitc.ctx, `SELECT uuid, name FROM hosts`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var uuid, name string
+		if err := rows.Scan(&uuid, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names.Hosts[uuid] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = oitc.db.QueryContext(oitc.ctx, `SELECT services.uuid, hosts.uuid, COALESCE(NULLIF(services.name, ''), servicetemplates.name) FROM services INNER JOIN hosts ON hosts.id = services.host_id INNER JOIN servicetemplates ON servicetemplates.id = services.servicetemplate_id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var uuid string
+		service := new(ServiceName)
+		if err := rows.Scan(&uuid, &service.HostUUID, &service.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names.Services[uuid] = service
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+type rollbackCommandLine struct {
+	journal string
+	logfile string
+	filter  rrdpath.RollbackFilter
+}
+
+// parseTime accepts RFC 3339 timestamps, dates and unix timestamps
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time \"%s\"", value)
+}
+
+func parseRollbackCli(args []string) (*rollbackCommandLine, error) {
+	var (
+		since, until string
+		err          error
+	)
+
+	cli := new(rollbackCommandLine)
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rollback [options]\n\nRestores the archived whisper files of converted rrd files recorded in the journal.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cli.journal, "journal", "/var/lib/rrd2whisper/journal.jsonl", "Path to the conversion journal")
+	fs.StringVar(&cli.logfile, "logfile", "/var/log/rrd2whisper.log", "Path to logfile")
+	fs.StringVar(&cli.filter.Hostname, "host", "", "only roll back the conversions of this host")
+	fs.StringVar(&cli.filter.Servicename, "service", "", "only roll back the conversions of this service")
+	fs.StringVar(&since, "since", "", "only roll back conversions finished after this time (RFC 3339, YYYY-MM-DD or unix timestamp)")
+	fs.StringVar(&until, "until", "", "only roll back conversions finished before this time (RFC 3339, YYYY-MM-DD or unix timestamp)")
+	fs.Parse(args)
+
+	if cli.journal == "" {
+		return cli, fmt.Errorf("-journal is required")
+	}
+	if _, err = os.Stat(cli.journal); os.IsNotExist(err) {
+		return cli, fmt.Errorf("journal does not exist")
+	}
+	if cli.filter.Since, err = parseTime(since); err != nil {
+		return cli, err
+	}
+	if cli.filter.Until, err = parseTime(until); err != nil {
+		return cli, err
+	}
+
+	return cli, nil
+}
+
+// runRollback is the main function of rrd2whisper rollback
+func runRollback(args []string) {
+	cli, err := parseRollbackCli(args)
+	if err != nil {
+		logging.LogFatal("%s", err)
+	}
+
+	lf, err := os.OpenFile(cli.logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logging.LogFatal("Could not open log file: %s", err)
+	}
+	defer lf.Close()
+	log.SetOutput(lf)
+
+	logging.Log("Version: %s", Version)
+
+	journal, err := rrdpath.OpenJournal(cli.journal)
+	if err != nil {
+		logging.LogFatal("%s", err)
+	}
+	defer journal.Close()
+
+	var total, failed int
+	for _, entry := range journal.Entries() {
+		if !cli.filter.Match(entry) {
+			continue
+		}
+		total++
+		if err := journal.Rollback(entry); err != nil {
+			failed++
+			logging.LogDisplay("error: Could not roll back %s: %s", entry.RrdPath, err)
+		} else {
+			logging.LogDisplay("rolled back %s", entry.RrdPath)
+		}
+	}
+	logging.LogDisplay("Rolled back %d of %d conversions", total-failed, total)
+}
@@ -0,0 +1,165 @@
+package rrdpath
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status of a journal entry
+const (
+	JournalDone       = "done"
+	JournalFailed     = "failed"
+	JournalRolledBack = "rolledback"
+	// JournalRollingBack is written before the files are restored and JournalRollbackFailed
+	// if the restore stopped, both are rolled back again by the next rollback.
+	JournalRollingBack    = "rollingback"
+	JournalRollbackFailed = "rollbackfailed"
+)
+
+// JournalFile is a whisper file written by a conversion
+type JournalFile struct {
+	Path      string `json:"path"`
+	Retention string `json:"retention"`
+	Points    int    `json:"points"`
+	// Archive is the path the previous whisper file was moved to
+	Archive string `json:"archive,omitempty"`
+	// Merged is the merge strategy used if the previous whisper file was merged
+	Merged string `json:"merged,omitempty"`
+}
+
+// JournalEntry records the result of the conversion of one rrd set
+type JournalEntry struct {
+	RrdPath     string        `json:"rrd"`
+	Hostname    string        `json:"host"`
+	Servicename string        `json:"service"`
+	Status      string        `json:"status"`
+	Started     time.Time     `json:"started"`
+	Finished    time.Time     `json:"finished"`
+	Version     string        `json:"version,omitempty"`
+	Labels      []string      `json:"labels"`
+	Points      int           `json:"points"`
+	Files       []JournalFile `json:"files,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	// Renamed maps the original names of the datasources that were renamed to avoid collisions
+	Renamed map[string]string `json:"renamed,omitempty"`
+}
+
+// Journal is an append only file with one json entry per line
+// The last entry of an rrd file decides if it must be converted again.
+type Journal struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries map[string]*JournalEntry
+}
+
+// OpenJournal reads the journal at path and opens it for appending
+// The file and its directory are created if they don't exist.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create journal directory: %s", err)
+	}
+	fl, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal: %s", err)
+	}
+
+	journal := &Journal{
+		file:    fl,
+		entries: make(map[string]*JournalEntry),
+	}
+	// offset is the end of the last valid line
+	var (
+		parseErr error
+		offset   int64
+	)
+	reader := bufio.NewReader(fl)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			if parseErr != nil {
+				return nil, closeWithError(fl, parseErr)
+			}
+			entry := new(JournalEntry)
+			if len(bytes.TrimSpace(data)) == 0 {
+				offset += int64(len(data))
+			} else if err := json.Unmarshal(data, entry); err != nil {
+				// only the last line may be broken by an interrupted write
+				parseErr = fmt.Errorf("could not parse journal line %d: %s", line, err)
+			} else if data[len(data)-1] != '\n' {
+				parseErr = fmt.Errorf("journal line %d is incomplete", line)
+			} else {
+				journal.entries[entry.RrdPath] = entry
+				offset += int64(len(data))
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, closeWithError(fl, fmt.Errorf("could not read journal: %s", err))
+		}
+	}
+	if parseErr != nil {
+		if err := fl.Truncate(offset); err != nil {
+			return nil, closeWithError(fl, fmt.Errorf("could not remove incomplete journal line: %s", err))
+		}
+	}
+	return journal, nil
+}
+
+func closeWithError(fl *os.File, err error) error {
+	fl.Close()
+	return err
+}
+
+// Write appends the entry to the journal
+func (journal *Journal) Write(entry *JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("could not create journal entry: %s", err)
+	}
+	data = append(data, '\n')
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if _, err := journal.file.Write(data); err != nil {
+		return fmt.Errorf("could not write journal: %s", err)
+	}
+	journal.entries[entry.RrdPath] = entry
+	return nil
+}
+
+// Last returns the latest entry of the rrd file or nil
+func (journal *Journal) Last(rrdPath string) *JournalEntry {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.entries[rrdPath]
+}
+
+// Entries returns the latest entry of every rrd file sorted by path
+func (journal *Journal) Entries() []*JournalEntry {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	entries := make([]*JournalEntry, 0, len(journal.entries))
+	for _, entry := range journal.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RrdPath < entries[j].RrdPath
+	})
+	return entries
+}
+
+// Close closes the journal file
+func (journal *Journal) Close() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.file.Close()
+}
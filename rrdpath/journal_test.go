@@ -0,0 +1,88 @@
+package rrdpath
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal", "journal.jsonl")
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := &RrdSet{RrdPath: "/perfdata/host1/service1.rrd", Hostname: "host1", Servicename: "service1", okPath: filepath.Join(dir, "service1.ok"), journal: journal}
+	failed := &RrdSet{RrdPath: "/perfdata/host1/service2.rrd", Hostname: "host1", Servicename: "service2", okPath: filepath.Join(dir, "service2.ok"), journal: journal}
+	if !done.Todo() || !failed.Todo() {
+		t.Fatal("rrd sets without journal entry must be converted")
+	}
+	if err := failed.Done(&JournalEntry{Started: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Failed(&JournalEntry{Started: time.Now()}, fmt.Errorf("broken")); err != nil {
+		t.Fatal(err)
+	}
+	if err := done.Done(&JournalEntry{Started: time.Now(), Points: 42, Files: []JournalFile{{Path: "/whisper/host1/service1/label1.wsp", Retention: "60:1440", Points: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(done.okPath); !os.IsNotExist(err) {
+		t.Error(".ok file must not be created with a journal")
+	}
+
+	// simulate a write interrupted by a crash
+	fl, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.WriteString(`{"rrd":"/perfdata/host1/service1.rrd","sta`)
+	fl.Close()
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done.journal = journal
+	failed.journal = journal
+	if done.Todo() {
+		t.Error("converted rrd set must not be converted again")
+	}
+	if !failed.Todo() {
+		t.Error("failed rrd set must be converted again")
+	}
+	if !Converted(journal, done.RrdPath) || Converted(journal, failed.RrdPath) || Converted(journal, "/perfdata/host2/service1.rrd") {
+		t.Error("Converted must match the journal")
+	}
+	entry := journal.Last(done.RrdPath)
+	if entry.Points != 42 || len(entry.Files) != 1 || entry.Hostname != "host1" || entry.Finished.IsZero() {
+		t.Errorf("unexpected journal entry %+v", entry)
+	}
+	if entry := journal.Last(failed.RrdPath); entry.Status != JournalFailed || entry.Error != "broken" {
+		t.Errorf("unexpected journal entry %+v", entry)
+	}
+	if err := failed.Done(&JournalEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("journal broken after interrupted write: %s", err)
+	}
+	defer journal.Close()
+	if entry := journal.Last(failed.RrdPath); entry.Status != JournalDone {
+		t.Errorf("unexpected journal entry %+v", entry)
+	}
+}
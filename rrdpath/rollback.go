@@ -0,0 +1,122 @@
+package rrdpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RollbackFilter selects the journal entries to roll back
+type RollbackFilter struct {
+	Hostname    string
+	Servicename string
+	// Since and Until limit the time the conversion finished, zero values are ignored
+	Since time.Time
+	Until time.Time
+}
+
+// Match checks if the journal entry is a successful conversion or an incomplete rollback
+// selected by the filter
+func (filter *RollbackFilter) Match(entry *JournalEntry) bool {
+	if entry.Status != JournalDone && !rollingBack(entry) {
+		return false
+	}
+	if filter.Hostname != "" && entry.Hostname != filter.Hostname {
+		return false
+	}
+	if filter.Servicename != "" && entry.Servicename != filter.Servicename {
+		return false
+	}
+	if !filter.Since.IsZero() && entry.Finished.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && entry.Finished.After(filter.Until) {
+		return false
+	}
+	return true
+}
+
+// rollingBack checks if a rollback of the entry was started but not completed
+func rollingBack(entry *JournalEntry) bool {
+	return entry.Status == JournalRollingBack || entry.Status == JournalRollbackFailed
+}
+
+// Rollback restores the whisper files that were replaced by the conversion of entry
+// The written whisper files are removed, the archived files are moved back and the .ok
+// file is deleted. The rollback is recorded in the journal before the first file is
+// touched, so an interrupted or failed rollback is continued by the next one. The
+// archived files are moved over the written ones, a missing archive of an incomplete
+// rollback was already restored.
+func (journal *Journal) Rollback(entry *JournalEntry) error {
+	resume := rollingBack(entry)
+	// check first, so the written files are not removed if the rollback can't be completed
+	for _, fl := range entry.Files {
+		if fl.Archive == "" {
+			continue
+		}
+		if _, err := os.Stat(fl.Archive); err != nil {
+			if resume && os.IsNotExist(err) && fileExists(fl.Path) {
+				continue
+			}
+			return fmt.Errorf("archived whisper file missing: %s", err)
+		}
+	}
+
+	rollback := *entry
+	rollback.Status = JournalRollingBack
+	rollback.Error = ""
+	if err := journal.Write(&rollback); err != nil {
+		return err
+	}
+	if err := restore(&rollback); err != nil {
+		rollback.Status = JournalRollbackFailed
+		rollback.Error = err.Error()
+		if journalErr := journal.Write(&rollback); journalErr != nil {
+			return fmt.Errorf("%s, %s", err, journalErr)
+		}
+		return err
+	}
+
+	now := time.Now()
+	return journal.Write(&JournalEntry{
+		RrdPath:     entry.RrdPath,
+		Hostname:    entry.Hostname,
+		Servicename: entry.Servicename,
+		Status:      JournalRolledBack,
+		Started:     now,
+		Finished:    now,
+		Labels:      entry.Labels,
+	})
+}
+
+// restore removes the written whisper files, moves the archived files back and deletes the .ok file
+func restore(entry *JournalEntry) error {
+	for _, fl := range entry.Files {
+		if fl.Archive == "" {
+			if err := os.Remove(fl.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("could not remove whisper file: %s", err)
+			}
+			continue
+		}
+		if !fileExists(fl.Archive) {
+			// restored by the interrupted rollback
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fl.Path), 0755); err != nil {
+			return fmt.Errorf("could not create destination directory: %s", err)
+		}
+		if err := os.Rename(fl.Archive, fl.Path); err != nil {
+			return fmt.Errorf("could not restore archived whisper file: %s", err)
+		}
+	}
+	if err := os.Remove(okPath(entry.RrdPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove .ok file: %s", err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
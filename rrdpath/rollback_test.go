@@ -0,0 +1,131 @@
+package rrdpath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal, err := OpenJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	for _, path := range []string{"perfdata/host1/service1.ok", "whisper/host1/service1/label1.wsp", "whisper/host1/service1/label2.wsp", "archive/host1/service1/label1.wsp"} {
+		path = filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rrdSet := &RrdSet{RrdPath: filepath.Join(dir, "perfdata/host1/service1.rrd"), Hostname: "host1", Servicename: "service1", okPath: filepath.Join(dir, "perfdata/host1/service1.ok"), journal: journal}
+	err = rrdSet.Done(&JournalEntry{Files: []JournalFile{
+		{Path: filepath.Join(dir, "whisper/host1/service1/label1.wsp"), Archive: filepath.Join(dir, "archive/host1/service1/label1.wsp")},
+		{Path: filepath.Join(dir, "whisper/host1/service1/label2.wsp")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := journal.Last(rrdSet.RrdPath)
+	if (&RollbackFilter{Hostname: "host2"}).Match(entry) || (&RollbackFilter{Until: time.Now().Add(-time.Hour)}).Match(entry) {
+		t.Error("filter must not match")
+	}
+	if !(&RollbackFilter{Hostname: "host1", Since: time.Now().Add(-time.Hour)}).Match(entry) {
+		t.Fatal("filter must match")
+	}
+	if err := journal.Rollback(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "whisper/host1/service1/label1.wsp"))
+	if err != nil || string(data) != filepath.Join(dir, "archive/host1/service1/label1.wsp") {
+		t.Errorf("archived whisper file not restored: %s", err)
+	}
+	for _, path := range []string{"whisper/host1/service1/label2.wsp", "archive/host1/service1/label1.wsp", "perfdata/host1/service1.ok"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Errorf("%s must be removed", path)
+		}
+	}
+	if !rrdSet.Todo() {
+		t.Error("rolled back rrd set must be converted again")
+	}
+	if (&RollbackFilter{}).Match(journal.Last(rrdSet.RrdPath)) {
+		t.Error("rolled back entry must not match")
+	}
+}
+
+func TestRollbackResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal, err := OpenJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	for _, path := range []string{"whisper/host1/service1/label1.wsp", "archive/run1/host1/service1/label1.wsp", "archive/run1/host1/service1/label2.wsp"} {
+		path = filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// label2 can't be restored, so the rollback fails after label1 was restored
+	blocker := filepath.Join(dir, "whisper/host1/service1/label2.wsp/x")
+	if err := os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rrdSet := &RrdSet{RrdPath: filepath.Join(dir, "perfdata/host1/service1.rrd"), Hostname: "host1", Servicename: "service1", okPath: filepath.Join(dir, "perfdata/host1/service1.ok"), journal: journal}
+	err = rrdSet.Done(&JournalEntry{Files: []JournalFile{
+		{Path: filepath.Join(dir, "whisper/host1/service1/label1.wsp"), Archive: filepath.Join(dir, "archive/run1/host1/service1/label1.wsp")},
+		{Path: filepath.Join(dir, "whisper/host1/service1/label2.wsp"), Archive: filepath.Join(dir, "archive/run1/host1/service1/label2.wsp")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := journal.Rollback(journal.Last(rrdSet.RrdPath)); err == nil {
+		t.Fatal("rollback must fail")
+	}
+	entry := journal.Last(rrdSet.RrdPath)
+	if entry.Status != JournalRollbackFailed || entry.Error == "" || len(entry.Files) != 2 {
+		t.Fatalf("failed rollback not recorded: %+v", entry)
+	}
+	if rrdSet.Todo() {
+		t.Error("rrd set must not be converted before the rollback is completed")
+	}
+	if !(&RollbackFilter{Hostname: "host1"}).Match(entry) {
+		t.Fatal("failed rollback must match")
+	}
+
+	if err := os.RemoveAll(filepath.Dir(blocker)); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Rollback(entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range []string{"label1", "label2"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "whisper/host1/service1", label+".wsp"))
+		if err != nil || string(data) != filepath.Join(dir, "archive/run1/host1/service1", label+".wsp") {
+			t.Errorf("%s: archived whisper file not restored: %s", label, err)
+		}
+	}
+	if entry := journal.Last(rrdSet.RrdPath); entry.Status != JournalRolledBack {
+		t.Errorf("expected status %s, got %s", JournalRolledBack, entry.Status)
+	}
+}
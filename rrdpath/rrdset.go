@@ -3,46 +3,188 @@ package rrdpath
 import (
 	"path/filepath"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Storage types of PNP4Nagios
+const (
+	// StorageSingle stores all datasources of a service in one rrd file
+	StorageSingle = "SINGLE"
+	// StorageMultiple stores every datasource in its own rrd file
+	StorageMultiple = "MULTIPLE"
+)
+
+// Datasource holds the metadata of a datasource from the xml file
+// Thresholds and limits that are not set are NaN.
+type Datasource struct {
+	Name string
+	Label string
+	Unit string
+	// Index is the DS number of the datasource
+	Index int
+	// Template is the PNP4Nagios template, the check command in openITCOCKPIT
+	Template string
+	RrdFile string
+	StorageType string
+	Warn float64
+	WarnMin float64
+	WarnMax float64
+	WarnRangeType string
+	Crit float64
+	CritMin float64
+	CritMax float64
+	CritRangeType string
+	Min float64
+	Max float64
+}
+
 // RrdSet holds all data that is needed to process the rrd file
+// RrdPath identifies the rrd set in the journal, with StorageMultiple it does not exist
+// and the datasources are read from RrdFiles.
 type RrdSet struct {
 	RrdPath string
+	// RrdFiles holds the rrd file of each datasource with StorageMultiple, otherwise only RrdPath
+	RrdFiles []string
+	StorageType string
 	Datasources []string
+	// Metadata holds the metadata of each datasource in the order of Datasources
+	Metadata []*Datasource
 	Hostname string
 	Servicename string
+	// HostDisplayName and ServiceDisplayName are the display names from the xml file
+	HostDisplayName string
+	ServiceDisplayName string
+	CheckCommand string
 	Updated bool
 	Time time.Time
 	okPath string
+	journal *Journal
 }
 
 // NewRrdSet abstracts the xml information to something usefull
 func NewRrdSet(xml *XMLNagios) *RrdSet {
 	ds := make([]string, len(xml.Datasources))
+	metadata := make([]*Datasource, len(xml.Datasources))
 	for i, c := range xml.Datasources {
 		ds[i] = c.Name
+		metadata[i] = newDatasource(&c)
 	}
 	hostDir := filepath.Dir(xml.Path)
 	serviceFile := filepath.Base(xml.Path)
+	rrdPath := xml.Path[:len(xml.Path)-4] + ".rrd"
+	storageType := StorageSingle
+	rrdFiles := []string{rrdPath}
+	// PNP4Nagios uses the same storage type for all datasources of a service
+	if len(metadata) > 0 && metadata[0].StorageType == StorageMultiple {
+		storageType = StorageMultiple
+		rrdFiles = make([]string, len(metadata))
+		for i, datasource := range metadata {
+			rrdFiles[i] = multipleRrdFile(rrdPath, datasource)
+		}
+	}
 	return &RrdSet{
-		okPath: xml.Path[:len(xml.Path)-4] + ".ok",
-		RrdPath: xml.Path[:len(xml.Path)-4] + ".rrd",
+		okPath: okPath(rrdPath),
+		RrdPath: rrdPath,
+		RrdFiles: rrdFiles,
+		StorageType: storageType,
 		Hostname: filepath.Base(hostDir),
 		Servicename: serviceFile[:len(serviceFile)-4],
 		Time: time.Unix(xml.TimeT, 0),
+		HostDisplayName: xml.DispHostname,
+		ServiceDisplayName: xml.DispServicedesc,
+		CheckCommand: xml.CheckCommand,
 		Datasources: ds,
+		Metadata: metadata,
 		Updated: xml.RrdTxt == "successful updated",
 	}
 }
 
-// Todo checks the age and if the .ok file exists
+// multipleRrdFile returns the rrd file of a datasource with StorageMultiple
+// Only the file name of RRDFILE is used, so the perfdata directory can be moved like with
+// StorageSingle. Without RRDFILE the name is built like PNP4Nagios does: <service>_<ds>.rrd
+func multipleRrdFile(rrdPath string, datasource *Datasource) string {
+	name := filepath.Base(datasource.RrdFile)
+	if datasource.RrdFile == "" {
+		name = strings.TrimSuffix(filepath.Base(rrdPath), ".rrd") + "_" + datasource.Name + ".rrd"
+	}
+	return filepath.Join(filepath.Dir(rrdPath), name)
+}
+
+func newDatasource(xds *XMLDatasource) *Datasource {
+	storageType := xds.StorageType
+	if storageType == "" {
+		storageType = StorageSingle
+	}
+	return &Datasource{
+		Name: xds.Name,
+		Label: xds.Label,
+		Unit: xds.Unit,
+		Index: xds.DS,
+		Template: xds.Template,
+		RrdFile: xds.RrdFile,
+		StorageType: storageType,
+		Warn: parseThreshold(xds.Warn),
+		WarnMin: parseThreshold(xds.WarnMin),
+		WarnMax: parseThreshold(xds.WarnMax),
+		WarnRangeType: xds.WarnRangeType,
+		Crit: parseThreshold(xds.Crit),
+		CritMin: parseThreshold(xds.CritMin),
+		CritMax: parseThreshold(xds.CritMax),
+		CritRangeType: xds.CritRangeType,
+		Min: parseThreshold(xds.Min),
+		Max: parseThreshold(xds.Max),
+	}
+}
+
+// parseThreshold returns NaN for empty or invalid values
+func parseThreshold(value string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// Unit returns the unit of measurement of the datasource at index
+func (rrdSet *RrdSet) Unit(index int) string {
+	if index < len(rrdSet.Metadata) {
+		return rrdSet.Metadata[index].Unit
+	}
+	return ""
+}
+
+// okPath returns the path of the .ok file of the rrd file
+func okPath(rrdPath string) string {
+	return strings.TrimSuffix(rrdPath, ".rrd") + ".ok"
+}
+
+// Todo checks if the rrd set was converted successfully before
+// The .ok files of older versions are checked if the journal has no entry. An incomplete
+// rollback must be finished before the rrd set is converted again.
 func (rrdSet *RrdSet) Todo() bool {
-	if _, err := os.Stat(rrdSet.okPath); os.IsNotExist(err) {
-		return true
+	return !converted(rrdSet.journal, rrdSet.RrdPath, rrdSet.okPath)
+}
+
+// Converted checks if the rrd file was converted successfully before
+// The .ok files of older versions are checked if journal is nil or has no entry.
+func Converted(journal *Journal, rrdPath string) bool {
+	return converted(journal, rrdPath, okPath(rrdPath))
+}
+
+func converted(journal *Journal, rrdPath, okPath string) bool {
+	if journal != nil {
+		if entry := journal.Last(rrdPath); entry != nil {
+			return entry.Status == JournalDone || rollingBack(entry)
+		}
+	}
+	if _, err := os.Stat(okPath); os.IsNotExist(err) {
+		return false
 	}
-	return false
+	return true
 }
 
 // TooOld checks the last time updated of xml file
@@ -53,8 +195,12 @@ func (rrdSet *RrdSet) TooOld(oldest time.Time) bool {
 	return true
 }
 
-// Done creates the .ok file
-func (rrdSet *RrdSet) Done() error {
+// Done records the successful conversion in the journal
+// Without a journal the .ok file is created and entry is ignored.
+func (rrdSet *RrdSet) Done(entry *JournalEntry) error {
+	if rrdSet.journal != nil {
+		return rrdSet.record(entry, JournalDone, "")
+	}
 	okFl, err := os.OpenFile(rrdSet.okPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("could not create %s file: %s", rrdSet.okPath, err)
@@ -63,6 +209,33 @@ func (rrdSet *RrdSet) Done() error {
 	return nil
 }
 
+// Failed records the failed conversion in the journal
+func (rrdSet *RrdSet) Failed(entry *JournalEntry, convertError error) error {
+	if rrdSet.journal == nil {
+		return nil
+	}
+	return rrdSet.record(entry, JournalFailed, convertError.Error())
+}
+
+// Entry returns the last journal entry of the rrd set or nil
+func (rrdSet *RrdSet) Entry() *JournalEntry {
+	if rrdSet.journal == nil {
+		return nil
+	}
+	return rrdSet.journal.Last(rrdSet.RrdPath)
+}
+
+func (rrdSet *RrdSet) record(entry *JournalEntry, status, message string) error {
+	entry.RrdPath = rrdSet.RrdPath
+	entry.Hostname = rrdSet.Hostname
+	entry.Servicename = rrdSet.Servicename
+	entry.Labels = rrdSet.Datasources
+	entry.Status = status
+	entry.Error = message
+	entry.Finished = time.Now()
+	return rrdSet.journal.Write(entry)
+}
+
 // Workdata counts all found xml files with there states
 type Workdata struct {
 	RrdSets []*RrdSet
@@ -74,7 +247,13 @@ type Workdata struct {
 }
 
 // NewWorkdata processes all found xml files for stats
-func NewWorkdata(rrdPath *RrdPath, oldest time.Time, limit int) (*Workdata, error) {
+// If journal is nil the .ok files are used to track converted rrd sets.
+func NewWorkdata(rrdPath *RrdPath, journal *Journal, oldest time.Time, limit int) (*Workdata, error) {
+	return NewWorkdataFilter(rrdPath, journal, oldest, limit, (*RrdSet).Todo)
+}
+
+// NewWorkdataFilter is like NewWorkdata but uses filter instead of Todo to select the rrd sets
+func NewWorkdataFilter(rrdPath *RrdPath, journal *Journal, oldest time.Time, limit int, filter func(*RrdSet) bool) (*Workdata, error) {
 	rrdSets := make([]*RrdSet, 0)
 	workdata := &Workdata{
 		TooOld: 0,
@@ -85,12 +264,13 @@ func NewWorkdata(rrdPath *RrdPath, oldest time.Time, limit int) (*Workdata, erro
 
 	for xml := range rrdPath.Results() {
 		rrd := NewRrdSet(xml)
+		rrd.journal = journal
 		workdata.Total++
 		if !rrd.Updated {
 			workdata.Corrupt++
 		} else if !oldest.IsZero() && rrd.TooOld(oldest) {
 			workdata.TooOld++
-		} else if rrd.Todo() {
+		} else if filter(rrd) {
 			rrdSets = append(rrdSets, rrd)
 			workdata.Todo++
 		}
@@ -19,7 +19,7 @@ func Test(t *testing.T) {
 
 	rrdPath := Walk(context.Background(), ts.Source)
 	var maxAge time.Time
-	workdata, err := NewWorkdata(rrdPath, maxAge, 0)
+	workdata, err := NewWorkdata(rrdPath, nil, maxAge, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -30,7 +30,7 @@ func Test(t *testing.T) {
 		t.Fatalf("Found %d datasources instead of 2", len(workdata.RrdSets[0].Datasources))
 	}
 	for _, rrdSet := range workdata.RrdSets {
-		if err := rrdSet.Done(); err != nil {
+		if err := rrdSet.Done(new(JournalEntry)); err != nil {
 			t.Errorf("RrdSet.Done(): %s", err)
 		}
 	}
@@ -47,7 +47,7 @@ func TestBrokenXML(t *testing.T) {
 
 	rrdPath := Walk(context.Background(), ts.Source)
 	var maxAge time.Time
-	wdata, err := NewWorkdata(rrdPath, maxAge, 0)
+	wdata, err := NewWorkdata(rrdPath, nil, maxAge, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -69,7 +69,7 @@ func TestCancel(t *testing.T) {
 	rrdPath := Walk(ctx, ts.Source)
 	var maxAge time.Time
 	cancel()
-	_, err = NewWorkdata(rrdPath, maxAge, 0)
+	_, err = NewWorkdata(rrdPath, nil, maxAge, 0)
 	if err == nil || err.Error() != "context canceled" {
 		t.Fatalf("err is not context canceled: %s", err)
 	}
@@ -13,8 +13,25 @@ import (
 )
 
 // XMLDatasource is holding the datasource structure of the rrd xml file
+// Thresholds and limits are kept as strings, because they are empty if not set.
 type XMLDatasource struct {
+	Template string `xml:"TEMPLATE"`
+	RrdFile string `xml:"RRDFILE"`
+	StorageType string `xml:"RRD_STORAGE_TYPE"`
+	DS int `xml:"DS"`
 	Name string `xml:"NAME"`
+	Label string `xml:"LABEL"`
+	Unit string `xml:"UNIT"`
+	Warn string `xml:"WARN"`
+	WarnMin string `xml:"WARN_MIN"`
+	WarnMax string `xml:"WARN_MAX"`
+	WarnRangeType string `xml:"WARN_RANGE_TYPE"`
+	Crit string `xml:"CRIT"`
+	CritMin string `xml:"CRIT_MIN"`
+	CritMax string `xml:"CRIT_MAX"`
+	CritRangeType string `xml:"CRIT_RANGE_TYPE"`
+	Min string `xml:"MIN"`
+	Max string `xml:"MAX"`
 }
 
 // XMLNagios is holding the structure of the rrd xml file
@@ -22,6 +39,13 @@ type XMLNagios struct {
 	XMLName     string `xml:"NAGIOS"`
 	RrdTxt      string `xml:"RRD>TXT"`
 	TimeT       int64  `xml:"NAGIOS_TIMET"`
+	Hostname string `xml:"NAGIOS_HOSTNAME"`
+	Servicedesc string `xml:"NAGIOS_SERVICEDESC"`
+	DispHostname string `xml:"NAGIOS_DISP_HOSTNAME"`
+	DispServicedesc string `xml:"NAGIOS_DISP_SERVICEDESC"`
+	CheckCommand string `xml:"NAGIOS_CHECK_COMMAND"`
+	RrdFile string `xml:"NAGIOS_RRDFILE"`
+	Version string `xml:"XML>VERSION"`
 	Datasources []XMLDatasource `xml:"DATASOURCE"`
 	Path string
 }
@@ -0,0 +1,151 @@
+package rrdpath
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<NAGIOS>
+<DATASOURCE>
+<TEMPLATE>check_ping</TEMPLATE>
+<RRDFILE>/perfdata/host1/service1.rrd</RRDFILE>
+<RRD_STORAGE_TYPE>SINGLE</RRD_STORAGE_TYPE>
+<RRD_HEARTBEAT>8460</RRD_HEARTBEAT>
+<IS_MULTI>0</IS_MULTI>
+<DS>1</DS>
+<NAME>rta</NAME>
+<LABEL>rta</LABEL>
+<UNIT>ms</UNIT>
+<ACT>0.05</ACT>
+<WARN>100.0</WARN>
+<WARN_MIN></WARN_MIN>
+<WARN_MAX></WARN_MAX>
+<WARN_RANGE_TYPE></WARN_RANGE_TYPE>
+<CRIT>500.0</CRIT>
+<CRIT_MIN></CRIT_MIN>
+<CRIT_MAX></CRIT_MAX>
+<CRIT_RANGE_TYPE></CRIT_RANGE_TYPE>
+<MIN>0</MIN>
+<MAX></MAX>
+</DATASOURCE>
+<DATASOURCE>
+<TEMPLATE>check_ping</TEMPLATE>
+<RRDFILE>/perfdata/host1/service1.rrd</RRDFILE>
+<RRD_STORAGE_TYPE>SINGLE</RRD_STORAGE_TYPE>
+<DS>2</DS>
+<NAME>pl</NAME>
+<LABEL>pl</LABEL>
+<UNIT>%</UNIT>
+<WARN>20</WARN>
+<WARN_MIN>0</WARN_MIN>
+<WARN_MAX>20</WARN_MAX>
+<WARN_RANGE_TYPE>0</WARN_RANGE_TYPE>
+<CRIT>60</CRIT>
+<MIN>0</MIN>
+<MAX>100</MAX>
+</DATASOURCE>
+<RRD>
+<RC>0</RC>
+<TXT>successful updated</TXT>
+</RRD>
+<NAGIOS_CHECK_COMMAND><![CDATA[check_ping!100.0,20%!500.0,60%]]></NAGIOS_CHECK_COMMAND>
+<NAGIOS_DISP_HOSTNAME>web.example.com</NAGIOS_DISP_HOSTNAME>
+<NAGIOS_DISP_SERVICEDESC>Ping</NAGIOS_DISP_SERVICEDESC>
+<NAGIOS_HOSTNAME>host1</NAGIOS_HOSTNAME>
+<NAGIOS_RRDFILE>/perfdata/host1/service1.rrd</NAGIOS_RRDFILE>
+<NAGIOS_SERVICEDESC>service1</NAGIOS_SERVICEDESC>
+<NAGIOS_TIMET>1600000000</NAGIOS_TIMET>
+<XML>
+<VERSION>4</VERSION>
+</XML>
+</NAGIOS>`
+
+func writeTestXML(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "host1", "service1.xml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseRrdXML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestXML(t, dir, testXML)
+
+	xmlNagios, err := parseRrdXML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xmlNagios.Version != "4" || xmlNagios.CheckCommand != "check_ping!100.0,20%!500.0,60%" {
+		t.Errorf("unexpected xml %+v", xmlNagios)
+	}
+
+	rrdSet := NewRrdSet(xmlNagios)
+	if rrdSet.Hostname != "host1" || rrdSet.Servicename != "service1" || !rrdSet.Updated {
+		t.Errorf("unexpected rrd set %+v", rrdSet)
+	}
+	if rrdSet.StorageType != StorageSingle || len(rrdSet.RrdFiles) != 1 || rrdSet.RrdFiles[0] != filepath.Join(dir, "host1", "service1.rrd") {
+		t.Errorf("unexpected rrd files %s %v", rrdSet.StorageType, rrdSet.RrdFiles)
+	}
+	if rrdSet.HostDisplayName != "web.example.com" || rrdSet.ServiceDisplayName != "Ping" {
+		t.Errorf("unexpected display names %s %s", rrdSet.HostDisplayName, rrdSet.ServiceDisplayName)
+	}
+	if len(rrdSet.Metadata) != 2 {
+		t.Fatalf("found %d datasources instead of 2", len(rrdSet.Metadata))
+	}
+	rta, pl := rrdSet.Metadata[0], rrdSet.Metadata[1]
+	if rta.Index != 1 || rta.Template != "check_ping" || rta.StorageType != StorageSingle || rrdSet.Unit(0) != "ms" {
+		t.Errorf("unexpected metadata %+v", rta)
+	}
+	if rta.Warn != 100 || rta.Crit != 500 || rta.Min != 0 || !math.IsNaN(rta.Max) || !math.IsNaN(rta.WarnMin) {
+		t.Errorf("unexpected thresholds %+v", rta)
+	}
+	if pl.Index != 2 || pl.WarnMax != 20 || pl.WarnRangeType != "0" || pl.Max != 100 || rrdSet.Unit(1) != "%" {
+		t.Errorf("unexpected metadata %+v", pl)
+	}
+	if rrdSet.Unit(2) != "" {
+		t.Error("unknown datasource must have no unit")
+	}
+}
+
+func TestParseRrdXMLMultiple(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rrd2whisper-xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := strings.Replace(testXML, "SINGLE", "MULTIPLE", -1)
+	// the perfdata directory was moved, only the file name of RRDFILE is used
+	content = strings.Replace(content, "<RRDFILE>/perfdata/host1/service1.rrd</RRDFILE>\n<RRD_STORAGE_TYPE>MULTIPLE</RRD_STORAGE_TYPE>\n<RRD_HEARTBEAT>", "<RRDFILE>/perfdata/host1/service1_rta.rrd</RRDFILE>\n<RRD_STORAGE_TYPE>MULTIPLE</RRD_STORAGE_TYPE>\n<RRD_HEARTBEAT>", 1)
+	content = strings.Replace(content, "<RRDFILE>/perfdata/host1/service1.rrd</RRDFILE>", "", 1)
+	path := writeTestXML(t, dir, content)
+
+	xmlNagios, err := parseRrdXML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrdSet := NewRrdSet(xmlNagios)
+	if rrdSet.StorageType != StorageMultiple {
+		t.Errorf("unexpected storage type %s", rrdSet.StorageType)
+	}
+	expected := []string{filepath.Join(dir, "host1", "service1_rta.rrd"), filepath.Join(dir, "host1", "service1_pl.rrd")}
+	if !reflect.DeepEqual(rrdSet.RrdFiles, expected) {
+		t.Errorf("expected rrd files %v, got %v", expected, rrdSet.RrdFiles)
+	}
+	if rrdSet.RrdPath != filepath.Join(dir, "host1", "service1.rrd") {
+		t.Errorf("unexpected rrd path %s", rrdSet.RrdPath)
+	}
+}
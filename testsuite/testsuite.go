@@ -19,7 +19,7 @@ import (
 
 const DAY = time.Duration(24*60*60) * time.Second
 
-var illegalCharactersRegexp = regexp.MustCompile(`[^a-zA-Z^0-9\\-\\.]`)
+var illegalCharactersRegexp = regexp.MustCompile(`[^a-zA-Z0-9\-.]`)
 
 func replaceIllegalCharacters(s string) string {
 	return illegalCharactersRegexp.ReplaceAllString(s, "_")
@@ -41,14 +41,16 @@ type xmlData struct {
 	Hostname    string
 	Servicename string
 	Number      int
+	StorageType string
 }
 
-func writeRrdXML(xmlpath, rrdpath, hostname, servicename string, pflist []*perfdata.Perfdata, lastUpdate int64, broken bool) {
+// writeRrdXML writes the xml file, rrdfiles holds the rrd file of each datasource with MULTIPLE
+func writeRrdXML(xmlpath, rrdpath string, rrdfiles []string, hostname, servicename string, pflist []*perfdata.Perfdata, lastUpdate int64, broken bool) {
 	xmlBegin := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><NAGIOS>`
 	xmlDS := template.Must(template.New("datasource").Parse(`<DATASOURCE>
 <TEMPLATE>cdd9ba25-a4d8-4261-a551-32164d4dde14</TEMPLATE>
 <RRDFILE>{{.RrdPath}}</RRDFILE>
-<RRD_STORAGE_TYPE>SINGLE</RRD_STORAGE_TYPE>
+<RRD_STORAGE_TYPE>{{.StorageType}}</RRD_STORAGE_TYPE>
 <RRD_HEARTBEAT>8460</RRD_HEARTBEAT>
 <IS_MULTI>0</IS_MULTI>
 <DS>{{.Number}}</DS>
@@ -108,6 +110,11 @@ func writeRrdXML(xmlpath, rrdpath, hostname, servicename string, pflist []*perfd
 			Servicename: servicename,
 			LastUpdate:  lastUpdate,
 			Number:      i,
+			StorageType: "SINGLE",
+		}
+		if rrdfiles != nil {
+			inData.RrdPath = rrdfiles[i]
+			inData.StorageType = "MULTIPLE"
 		}
 		xmlDS.Execute(&xmlOut, inData)
 	}
@@ -157,16 +164,14 @@ func generateRandomTimeSeriesRrd(unixFrom, unixTo int64, pflist []*perfdata.Perf
 }
 
 type RrdTestData struct {
-	Path       string
+	Path string
+	// Files holds the rrd file of each datasource with MULTIPLE
+	Files      []string
 	XMLFile    string
 	TimeSeries [][]string
 }
 
-func CreateRrd(path string, hostname string, servicename string, pflist []*perfdata.Perfdata, from time.Time, to time.Time, brokenXML bool) *RrdTestData {
-	var (
-		rrdPath = fmt.Sprintf("%s/%s/%s.rrd", path, hostname, servicename)
-		xmlPath = fmt.Sprintf("%s/%s/%s.xml", path, hostname, servicename)
-	)
+func createRrdFile(rrdPath string, pflist []*perfdata.Perfdata, from time.Time, values [][]string) {
 	os.MkdirAll(filepath.Dir(rrdPath), 0755)
 
 	rrdFile := rrd.NewCreator(rrdPath, from, 60)
@@ -202,18 +207,56 @@ func CreateRrd(path string, hostname string, servicename string, pflist []*perfd
 	if rrdUpd == nil {
 		panic("could not update rrd")
 	}
-	perfValues := generateRandomTimeSeriesRrd(from.Unix(), to.Unix(), pflist)
-	for _, values := range perfValues {
-		rrdUpd.Cache(strings.Join(values, ":"))
+	for _, row := range values {
+		rrdUpd.Cache(strings.Join(row, ":"))
 	}
 	if err := rrdUpd.Update(); err != nil {
 		panic(err)
 	}
+}
+
+func CreateRrd(path string, hostname string, servicename string, pflist []*perfdata.Perfdata, from time.Time, to time.Time, brokenXML bool) *RrdTestData {
+	var (
+		rrdPath = fmt.Sprintf("%s/%s/%s.rrd", path, hostname, servicename)
+		xmlPath = fmt.Sprintf("%s/%s/%s.xml", path, hostname, servicename)
+	)
+
+	perfValues := generateRandomTimeSeriesRrd(from.Unix(), to.Unix(), pflist)
+	createRrdFile(rrdPath, pflist, from, perfValues)
+
+	writeRrdXML(xmlPath, rrdPath, nil, hostname, servicename, pflist, to.Unix(), brokenXML)
+
+	return &RrdTestData{
+		Path:       rrdPath,
+		XMLFile:    xmlPath,
+		TimeSeries: perfValues,
+	}
+}
+
+// CreateMultipleRrd creates the rrd files like PNP4Nagios with RRD_STORAGE_TYPE MULTIPLE,
+// one <service>_<label>.rrd for each datasource
+func CreateMultipleRrd(path string, hostname string, servicename string, pflist []*perfdata.Perfdata, from time.Time, to time.Time) *RrdTestData {
+	var (
+		rrdPath = fmt.Sprintf("%s/%s/%s.rrd", path, hostname, servicename)
+		xmlPath = fmt.Sprintf("%s/%s/%s.xml", path, hostname, servicename)
+	)
+
+	perfValues := generateRandomTimeSeriesRrd(from.Unix(), to.Unix(), pflist)
+	files := make([]string, len(pflist))
+	for i, pf := range pflist {
+		files[i] = fmt.Sprintf("%s/%s/%s_%s.rrd", path, hostname, servicename, replaceIllegalCharacters(pf.Label))
+		values := make([][]string, len(perfValues))
+		for r, row := range perfValues {
+			values[r] = []string{row[0], row[i+1]}
+		}
+		createRrdFile(files[i], []*perfdata.Perfdata{pf}, from, values)
+	}
 
-	writeRrdXML(xmlPath, rrdPath, hostname, servicename, pflist, to.Unix(), brokenXML)
+	writeRrdXML(xmlPath, rrdPath, files, hostname, servicename, pflist, to.Unix(), false)
 
 	return &RrdTestData{
 		Path:       rrdPath,
+		Files:      files,
 		XMLFile:    xmlPath,
 		TimeSeries: perfValues,
 	}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/it-novum/rrd2whisper/converter"
+	"github.com/it-novum/rrd2whisper/logging"
+	"github.com/it-novum/rrd2whisper/rrdpath"
+)
+
+type verifyCommandLine struct {
+	commandLine
+	filter    rrdSetFilter
+	tolerance float64
+	minMax    bool
+}
+
+func parseVerifyCli(args []string) (*verifyCommandLine, error) {
+	var err error
+
+	cli := new(verifyCommandLine)
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [options]\n\nCompares the whisper files of converted rrd files with the finest AVERAGE rra,\nwith -min-max also the .min and .max files with the finest MIN and MAX rra.\nThe coarser rra's are not compared.\nExits with status 1 if a value is missing or does not match. Files merged with\nprefer-whisper (or newest-wins with newer whisper data) are expected to differ,\ntheir mismatches are reported as MERGED if the journal recorded the merge.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cli.sourceDirectory, "source", "/opt/openitc/nagios/share/perfdata", "Path to source directory file tree of rrd files")
+	fs.StringVar(&cli.destDirectory, "destination", "/var/lib/graphite/whisper/openitcockpit", "Destination of file tree for whisper")
+	cli.filter.addFlags(fs, "verify")
+	fs.BoolVar(&cli.minMax, "min-max", false, "also compare the <label>.min.wsp and <label>.max.wsp files created with -min-max")
+	fs.Float64Var(&cli.tolerance, "tolerance", 0.0001, "allowed deviation relative to the rrd value (absolute for values below 1)")
+	fs.Int64Var(&cli.maxAge, "max-age", 1209600, "Maximum age of an rrd file to be included (in seconds since last update, default 2 weeks, 0=all)")
+	fs.IntVar(&cli.limit, "limit", 0, "Limit number of rrd's, 0=unlimited")
+	fs.StringVar(&cli.journal, "journal", "/var/lib/rrd2whisper/journal.jsonl", "Path to the conversion journal, if empty the .ok files are used")
+	fs.StringVar(&cli.logfile, "logfile", "/var/log/rrd2whisper.log", "Path to logfile")
+	addDatabaseFlags(fs, &cli.commandLine)
+	addNamingFlags(fs, &cli.commandLine)
+	fs.Parse(args)
+
+	if cli.tolerance < 0 {
+		return cli, fmt.Errorf("-tolerance must not be negative")
+	}
+	if _, err = os.Stat(cli.sourceDirectory); os.IsNotExist(err) {
+		return cli, fmt.Errorf("source directory does not exist")
+	}
+	if cli.sourceDirectory, err = filepath.Abs(cli.sourceDirectory); err != nil {
+		return cli, fmt.Errorf("could not get absolute path of source directory: %s", err)
+	}
+	if err = checkDatabaseFlags(&cli.commandLine); err != nil {
+		return cli, err
+	}
+	if err = checkNamingFlags(&cli.commandLine); err != nil {
+		return cli, err
+	}
+
+	return cli, nil
+}
+
+// runVerify is the main function of rrd2whisper verify
+func runVerify(args []string) {
+	cli, err := parseVerifyCli(args)
+	if err != nil {
+		logging.LogFatal("%s", err)
+	}
+
+	lf, err := os.OpenFile(cli.logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logging.LogFatal("Could not open log file: %s", err)
+	}
+	defer lf.Close()
+	log.SetOutput(lf)
+
+	logging.Log("Version: %s", Version)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canSig := make(chan os.Signal, 1)
+	signal.Notify(canSig, os.Interrupt)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-canSig:
+			cancel()
+		}
+	}()
+
+	perfdata := loadPerfdata(ctx, &cli.commandLine)
+	// the mapping of the conversion, the names in the database may have changed since
+	pathTemplate := loadPathTemplate(ctx, &cli.commandLine, false)
+
+	var oldest time.Time
+	if cli.maxAge > 0 {
+		oldest = time.Now().Add(-time.Duration(cli.maxAge) * time.Second)
+	}
+	var journal *rrdpath.Journal
+	if _, err := os.Stat(cli.journal); cli.journal != "" && err == nil {
+		if journal, err = rrdpath.OpenJournal(cli.journal); err != nil {
+			logging.LogFatal("%s", err)
+		}
+		defer journal.Close()
+	}
+	converted := func(rrdSet *rrdpath.RrdSet) bool {
+		return !rrdSet.Todo() && cli.filter.match(rrdSet)
+	}
+	workdata, err := rrdpath.NewWorkdataFilter(rrdpath.Walk(ctx, cli.sourceDirectory), journal, oldest, cli.limit, converted)
+	if err != nil {
+		logging.LogFatal("Could not scan rrd path: %s", err)
+	}
+	logging.LogDisplay("Verifying %d converted rrd files", len(workdata.RrdSets))
+
+	cvt := &converter.Converter{Destination: cli.destDirectory, UUIDToPerfdata: perfdata, PathTemplate: pathTemplate, MinMax: cli.minMax}
+	metrics, failed, merged := 0, 0, 0
+	for _, rrdSet := range workdata.RrdSets {
+		results, err := cvt.Verify(ctx, rrdSet, cli.tolerance)
+		if err != nil {
+			if ctx.Err() != nil {
+				logging.LogFatal("verify canceled")
+			}
+			failed++
+			logging.LogDisplay("FAIL %s: %s", rrdSet.RrdPath, err)
+			continue
+		}
+		for _, result := range results {
+			metrics++
+			if result.MergedMismatch() {
+				merged++
+			} else if !result.OK() {
+				failed++
+			}
+			logging.LogDisplay("%s", result)
+		}
+	}
+	logging.LogDisplay("Verified %d metrics, %d failed, %d differ because they were merged", metrics, failed, merged)
+	if failed > 0 {
+		lf.Close()
+		os.Exit(1)
+	}
+}